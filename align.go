@@ -0,0 +1,184 @@
+// Copyright (c) 2013 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graph
+
+import (
+	"errors"
+	"fmt"
+)
+
+// A Segment is the portion of the observation sequence aligned to a label.
+type Segment struct {
+	// Label, the key of an emitting node.
+	Label string
+	// Index of the first and last observation in the segment.
+	Start, End int
+	// Score of the segment including the weights of the arcs that go
+	// into it. The score of the last segment includes the final weight.
+	// The scores add up to the total score of the alignment.
+	Score float64
+}
+
+// Align finds the best path in the graph whose sequence of emitting
+// nodes matches labels, each label spanning one or more consecutive
+// observations (forced alignment). Each label is the key of an emitting
+// node; consecutive identical labels are aligned to separate segments.
+// The path must reach the end node after the last observation (see
+// RequireFinal). The graph must satisfy the requirements of NewDecoder.
+// Returns one segment per label.
+func Align(g *Graph, labels []string, obs []interface{}) ([]Segment, error) {
+
+	if len(labels) == 0 {
+		return nil, errors.New("graph: no labels to align")
+	}
+	// Only the start and final nodes are needed, the network of the
+	// alignment graph is compiled instead.
+	net, e := newNetwork(g, DecoderOptions{})
+	if e != nil {
+		return nil, e
+	}
+	ag, opt, e := net.alignGraph(labels)
+	if e != nil {
+		return nil, e
+	}
+	anet, e := NewNetwork(ag.g, opt)
+	if e != nil {
+		return nil, e
+	}
+	dec := anet.NewDecoder()
+	for _, o := range obs {
+		dec.Step(o)
+	}
+	best, e := dec.Finalize()
+	if e != nil {
+		return nil, e
+	}
+	return ag.segments(best, labels), nil
+}
+
+// Graph that only accepts paths that match a label sequence. Each node
+// is a pair (node, position) where position is the number of labels
+// started so far. Emitting nodes at position p match label p-1 and null
+// nodes can be at any position.
+type alignGraph struct {
+	g   *Graph
+	pos map[*Node]int
+}
+
+func (net *Network) alignGraph(labels []string) (*alignGraph, DecoderOptions, error) {
+
+	ag := &alignGraph{g: New(), pos: make(map[*Node]int)}
+	ag.g.Meta().Weights = LogWeight
+	opt := DecoderOptions{
+		Initial: make(map[string]float64),
+		Final:   make(map[string]float64),
+	}
+
+	emitting := make(map[string]*Node, len(labels))
+	for _, label := range labels {
+		node := net.graph.get(label)
+		if node == nil || node == net.start || node == net.end || isNull(node) {
+			return nil, opt, fmt.Errorf("graph: label [%s] is not an emitting node", label)
+		}
+		emitting[label] = node
+	}
+	var nulls []*Node
+	for _, node := range net.graph.nodes {
+		if node != net.start && node != net.end && isNull(node) {
+			nulls = append(nulls, node)
+		}
+	}
+
+	key := func(node *Node, p int) string { return fmt.Sprintf("%s:%d", node.key, p) }
+	add := func(node *Node, p int) {
+		ag.pos[ag.g.Set(key(node, p), node.value)] = p
+	}
+	for p := 0; p <= len(labels); p++ {
+		for _, node := range nulls {
+			add(node, p)
+		}
+		if p > 0 {
+			add(emitting[labels[p-1]], p)
+		}
+	}
+
+	// Returns the keys of the successors of node at position p.
+	next := func(to *Node, p int) []string {
+		if isNull(to) {
+			return []string{key(to, p)}
+		}
+		var keys []string
+		if p > 0 && to.key == labels[p-1] {
+			keys = append(keys, key(to, p))
+		}
+		if p < len(labels) && to.key == labels[p] {
+			keys = append(keys, key(to, p+1))
+		}
+		return keys
+	}
+	connect := func(from *Node, p int) {
+		for to, w := range from.successors {
+			if to == net.end {
+				continue
+			}
+			for _, k := range next(to, p) {
+				ag.g.Connect(key(from, p), k, w)
+			}
+		}
+	}
+	for p := 0; p <= len(labels); p++ {
+		for _, node := range nulls {
+			connect(node, p)
+		}
+		if p > 0 {
+			connect(emitting[labels[p-1]], p)
+		}
+	}
+
+	for to, w := range net.start.successors {
+		if to == net.end {
+			continue
+		}
+		for _, k := range next(to, 0) {
+			opt.Initial[k] = w
+		}
+	}
+	last := emitting[labels[len(labels)-1]]
+	if w, ok := net.final[last]; ok {
+		opt.Final[key(last, len(labels))] = w
+	}
+	if len(opt.Initial) == 0 || len(opt.Final) == 0 {
+		return nil, opt, ErrNoCompletePath
+	}
+	return ag, opt, nil
+}
+
+// Splits the best path into segments.
+func (ag *alignGraph) segments(best *Token, labels []string) []Segment {
+
+	segs := make([]Segment, len(labels))
+	// Accumulated score at the end of each segment.
+	ends := make([]float64, len(labels))
+	for _, t := range best.Best() {
+		if t.IsNull() {
+			continue
+		}
+		k := ag.pos[t.Node] - 1
+		if segs[k].Label == "" {
+			segs[k] = Segment{Label: labels[k], Start: t.Index}
+		}
+		segs[k].End = t.Index
+		ends[k] = t.Score
+	}
+	ends[len(ends)-1] = best.Score
+	for k := range segs {
+		segs[k].Score = ends[k]
+		if k > 0 {
+			segs[k].Score -= ends[k-1]
+		}
+	}
+	return segs
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2013 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graph
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAlign(t *testing.T) {
+
+	g := nullGraph()
+	obs := []interface{}{1, 1, 2, 2, 1, 2}
+	segs, e := Align(g, []string{"a", "b", "a", "b"}, obs)
+	if e != nil {
+		t.Fatal(e)
+	}
+	var bounds [][2]int
+	var total float64
+	for _, s := range segs {
+		bounds = append(bounds, [2]int{s.Start, s.End})
+		total += s.Score
+	}
+	expected := [][2]int{{0, 1}, {2, 3}, {4, 4}, {5, 5}}
+	if !reflect.DeepEqual(bounds, expected) {
+		t.Fatalf("expected segments %v, got %v", expected, bounds)
+	}
+
+	// The transcription is the unconstrained best path.
+	dec, e := NewDecoder(g)
+	if e != nil {
+		t.Fatal(e)
+	}
+	dec.SetEndMode(RequireFinal)
+	for _, o := range obs {
+		dec.Step(o)
+	}
+	best, e := dec.Finalize()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !Comparef64(total, best.Score, 1e-9) {
+		t.Fatalf("expected total score [%f], got [%f]", best.Score, total)
+	}
+}
+
+func TestAlignConstrained(t *testing.T) {
+
+	// The observations favor [a] but the transcription forces [b].
+	g := nullGraph()
+	obs := []interface{}{1, 1, 1, 2}
+	segs, e := Align(g, []string{"a", "a", "b"}, obs)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(segs) != 3 || segs[0].Start != 0 || segs[2].Start != 3 || segs[2].End != 3 {
+		t.Fatalf("unexpected segments %+v", segs)
+	}
+	if segs[1].Start != segs[0].End+1 || segs[1].End != 2 {
+		t.Fatalf("segments are not contiguous %+v", segs)
+	}
+	if segs[0].Label != "a" || segs[1].Label != "a" || segs[2].Label != "b" {
+		t.Fatalf("unexpected labels %+v", segs)
+	}
+
+	segs, e = Align(g, []string{"b"}, obs)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if segs[0].Start != 0 || segs[0].End != 3 {
+		t.Fatalf("unexpected segments %+v", segs)
+	}
+}
+
+func TestAlignErrors(t *testing.T) {
+
+	g := nullGraph()
+	obs := []interface{}{1, 2}
+	for _, labels := range [][]string{nil, {"x"}, {"n1"}, {"start"}} {
+		if _, e := Align(g, labels, obs); e == nil {
+			t.Fatalf("labels %v: expected error", labels)
+		}
+	}
+
+	// Not enough observations.
+	if _, e := Align(g, []string{"a", "b", "a", "b"}, obs); e != ErrNoCompletePath {
+		t.Fatalf("expected ErrNoCompletePath, got [%v]", e)
+	}
+	// Null cycles are found when the alignment graph is compiled.
+	cyclic := nullGraph()
+	cyclic.Connect("n2", "n1", 0)
+	if _, e := Align(cyclic, []string{"a", "b"}, obs); e == nil || !strings.Contains(e.Error(), "cycle") {
+		t.Fatalf("expected null cycle error, got [%v]", e)
+	}
+
+	// Path doesn't end in [b].
+	if _, e := Align(g, []string{"b", "a"}, obs); e != ErrNoCompletePath {
+		t.Fatalf("expected ErrNoCompletePath, got [%v]", e)
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright (c) 2013 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graph
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// The Trainable interface is implemented by node values whose emission
+// parameters can be re-estimated by BaumWelch.
+type Trainable interface {
+	Viterbier
+	// Accumulate adds an observation with a weight equal to the posterior
+	// probability of the node.
+	Accumulate(obs interface{}, weight float64)
+	// Update re-estimates the parameters using the accumulated statistics
+	// and resets the accumulators.
+	Update() error
+}
+
+// BaumWelchOptions are the options for BaumWelch.
+type BaumWelchOptions struct {
+	// Maximum number of iterations. If zero, 10 is used.
+	MaxIter int
+	// Training stops when the log likelihood per observation improves
+	// less than Threshold.
+	Threshold float64
+	// Number of sequences processed in parallel. If zero, the number of
+	// CPUs is used.
+	Workers int
+	// Re-estimate emission parameters of nodes whose values implement
+	// the Trainable interface.
+	UpdateEmissions bool
+	// Minimum arc probability. Arcs with a lower estimated probability
+	// are set to Floor before renormalization. If zero, arcs that are
+	// never traversed get a log weight of -Inf.
+	Floor float64
+}
+
+// Accumulated statistics for one iteration.
+type bwStats struct {
+	counts  map[*Node]map[*Node]float64
+	ll      float64
+	numObs  int
+	skipped int
+}
+
+func (s *bwStats) add(p *Posteriors) {
+	for from, m := range p.ArcCounts {
+		if s.counts[from] == nil {
+			s.counts[from] = make(map[*Node]float64)
+		}
+		for to, c := range m {
+			s.counts[from][to] += c
+		}
+	}
+	s.ll += p.LogLikelihood
+}
+
+// BaumWelch re-estimates the arc weights of the graph in place using the
+// Baum-Welch algorithm on a corpus of observation sequences. The graph
+// must satisfy the requirements of NewForwardBackward. Arcs that go into
+// the end node are not traversed by the forward-backward algorithm; their
+// weights are not modified and the remaining arcs share the rest of the
+// probability mass. Sequences with zero likelihood are skipped.
+// Score methods are called concurrently and must be safe for concurrent
+// use; Accumulate and Update are called from a single goroutine.
+// The graph is re-estimated in every iteration, including the one that
+// meets the convergence threshold. Returns the total log likelihood of
+// the corpus before each iteration.
+func (g *Graph) BaumWelch(corpus [][]interface{}, opt BaumWelchOptions) ([]float64, error) {
+
+	if opt.MaxIter == 0 {
+		opt.MaxIter = 10
+	}
+	if opt.Workers <= 0 {
+		opt.Workers = runtime.NumCPU()
+	}
+
+	var lls []float64
+	prev := math.Inf(-1)
+	for iter := 0; iter < opt.MaxIter; iter++ {
+		fb, e := NewForwardBackward(g)
+		if e != nil {
+			return lls, e
+		}
+		stats := g.bwAccumulate(fb, corpus, opt)
+		if stats.numObs == 0 {
+			return lls, ErrZeroLikelihood
+		}
+		lls = append(lls, stats.ll)
+		glog.V(2).Infof("baum-welch iteration: %d, log likelihood: %f, skipped: %d", iter, stats.ll, stats.skipped)
+
+		// Statistics are always used so the accumulators are reset
+		// before returning.
+		g.bwUpdate(fb, stats.counts, opt.Floor)
+		if opt.UpdateEmissions {
+			for _, node := range g.nodes {
+				if tr, ok := node.value.(Trainable); ok {
+					if e := tr.Update(); e != nil {
+						return lls, fmt.Errorf("graph: updating node [%s]: %v", node.key, e)
+					}
+				}
+			}
+		}
+
+		avg := stats.ll / float64(stats.numObs)
+		if iter > 0 && avg-prev < opt.Threshold {
+			break
+		}
+		prev = avg
+	}
+	return lls, nil
+}
+
+// Runs forward-backward on all sequences and accumulates statistics.
+func (g *Graph) bwAccumulate(fb *ForwardBackward, corpus [][]interface{}, opt BaumWelchOptions) *bwStats {
+
+	type result struct {
+		seq int
+		p   *Posteriors
+	}
+	jobs := make(chan int)
+	results := make(chan result)
+	var wg sync.WaitGroup
+	for w := 0; w < opt.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := range jobs {
+				p, e := fb.Compute(corpus[k])
+				if e != nil {
+					glog.Warningf("skipping sequence %d: %v", k, e)
+					p = nil
+				}
+				results <- result{seq: k, p: p}
+			}
+		}()
+	}
+	go func() {
+		for k := range corpus {
+			jobs <- k
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	stats := &bwStats{counts: make(map[*Node]map[*Node]float64)}
+	for r := range results {
+		if r.p == nil {
+			stats.skipped++
+			continue
+		}
+		stats.add(r.p)
+		stats.numObs += len(corpus[r.seq])
+		if !opt.UpdateEmissions {
+			continue
+		}
+		for i, key := range r.p.Keys {
+			tr, ok := g.nodes[key].value.(Trainable)
+			if !ok {
+				continue
+			}
+			for t, o := range corpus[r.seq] {
+				if w := r.p.Gamma[t][i]; w > 0 {
+					tr.Accumulate(o, w)
+				}
+			}
+		}
+	}
+	return stats
+}
+
+// Sets arc weights to the log of the normalized expected counts.
+func (g *Graph) bwUpdate(fb *ForwardBackward, counts map[*Node]map[*Node]float64, floor float64) {
+
+	end := fb.nodes[fb.end]
+	for from, m := range counts {
+		var total float64
+		for _, c := range m {
+			total += c
+		}
+		if total == 0 {
+			continue
+		}
+
+		// Probability mass not assigned to the end node.
+		mass := 1.0
+		if w, ok := from.successors[end]; ok {
+			mass -= math.Exp(w)
+		}
+
+		probs := make(map[*Node]float64, len(from.successors))
+		var sum float64
+		for to := range from.successors {
+			if to == end {
+				continue
+			}
+			p := m[to] / total
+			if p < floor {
+				p = floor
+			}
+			probs[to] = p
+			sum += p
+		}
+		for to, p := range probs {
+			from.successors[to] = math.Log(mass * p / sum)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2013 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graph
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// Node value with a discrete emission distribution.
+type dvalue struct {
+	null  bool
+	probs []float64
+	acc   []float64
+}
+
+func (v *dvalue) Score(o interface{}) float64 { return math.Log(v.probs[o.(int)]) }
+func (v *dvalue) IsNull() bool                { return v.null }
+
+func (v *dvalue) Emit(rng *rand.Rand) interface{} {
+	u := rng.Float64()
+	for k, p := range v.probs {
+		u -= p
+		if u < 0 {
+			return k
+		}
+	}
+	return len(v.probs) - 1
+}
+
+func (v *dvalue) Accumulate(o interface{}, w float64) {
+	if v.acc == nil {
+		v.acc = make([]float64, len(v.probs))
+	}
+	v.acc[o.(int)] += w
+}
+
+func (v *dvalue) Update() error {
+	var sum float64
+	for _, c := range v.acc {
+		sum += c
+	}
+	if sum > 0 {
+		for k, c := range v.acc {
+			v.probs[k] = c / sum
+		}
+	}
+	v.acc = nil
+	return nil
+}
+
+// Two state model.
+func discreteGraph(aa, ea, eb []float64) *Graph {
+
+	g := New()
+	g.Set("start", &dvalue{null: true})
+	g.Set("a", &dvalue{probs: ea})
+	g.Set("b", &dvalue{probs: eb})
+	g.Set("end", &dvalue{null: true})
+	g.Connect("start", "a", 1)
+	g.Connect("a", "a", aa[0])
+	g.Connect("a", "b", aa[1])
+	g.Connect("b", "b", 0.8)
+	g.Connect("b", "end", 0.2)
+	return g
+}
+
+// Samples a corpus from the true model.
+func sampleCorpus(t *testing.T, n int) [][]interface{} {
+
+	g := discreteGraph([]float64{0.7, 0.3}, []float64{0.8, 0.1, 0.1}, []float64{0.1, 0.2, 0.7})
+	s, e := NewSampler(g, rand.New(rand.NewSource(3)))
+	if e != nil {
+		t.Fatal(e)
+	}
+	var corpus [][]interface{}
+	for len(corpus) < n {
+		_, obs, e := s.Sample(50)
+		if e != nil {
+			t.Fatal(e)
+		}
+		corpus = append(corpus, obs)
+	}
+	return corpus
+}
+
+func TestBaumWelch(t *testing.T) {
+
+	corpus := sampleCorpus(t, 300)
+	g := discreteGraph([]float64{0.5, 0.5}, []float64{0.8, 0.1, 0.1}, []float64{0.1, 0.2, 0.7})
+	g.ConvertToLogProbs()
+
+	lls, e := g.BaumWelch(corpus, BaumWelchOptions{MaxIter: 20, Threshold: 1e-6})
+	if e != nil {
+		t.Fatal(e)
+	}
+	for k := 1; k < len(lls); k++ {
+		if lls[k] < lls[k-1]-1e-6 {
+			t.Fatalf("log likelihood decreased: %v", lls)
+		}
+	}
+	_, w := g.IsConnected("a", "a")
+	if !Comparef64(math.Exp(w), 0.7, 0.05) {
+		t.Fatalf("expected weight close to [0.7], got [%f]", math.Exp(w))
+	}
+	checkSums(t, g, true)
+
+	// End arcs are not modified.
+	if _, w = g.IsConnected("b", "end"); !Comparef64(w, math.Log(0.2), 1e-12) {
+		t.Fatalf("end arc modified: [%f]", math.Exp(w))
+	}
+}
+
+func TestBaumWelchEmissions(t *testing.T) {
+
+	corpus := sampleCorpus(t, 300)
+	g := discreteGraph([]float64{0.5, 0.5}, []float64{0.4, 0.3, 0.3}, []float64{0.3, 0.3, 0.4})
+	g.ConvertToLogProbs()
+
+	lls, e := g.BaumWelch(corpus, BaumWelchOptions{MaxIter: 30, UpdateEmissions: true})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(lls) != 30 {
+		t.Fatalf("expected 30 iterations, got [%d]", len(lls))
+	}
+	for k := 1; k < len(lls); k++ {
+		if lls[k] < lls[k-1]-1e-6 {
+			t.Fatalf("log likelihood decreased: %v", lls)
+		}
+	}
+	a, _ := g.Get("a")
+	if p := a.Value().(*dvalue).probs[0]; p < 0.5 {
+		t.Fatalf("expected emission probability close to [0.8], got [%f]", p)
+	}
+
+	// Accumulators are reset when training converges.
+	if _, e := g.BaumWelch(corpus, BaumWelchOptions{MaxIter: 30, Threshold: 1, UpdateEmissions: true}); e != nil {
+		t.Fatal(e)
+	}
+	for _, key := range []string{"a", "b"} {
+		n, _ := g.Get(key)
+		if acc := n.Value().(*dvalue).acc; acc != nil {
+			t.Fatalf("accumulators of node [%s] not reset: %v", key, acc)
+		}
+	}
+}
+
+func TestBaumWelchWorkers(t *testing.T) {
+
+	corpus := sampleCorpus(t, 50)
+	var weights []float64
+	for _, workers := range []int{1, 4} {
+		g := discreteGraph([]float64{0.5, 0.5}, []float64{0.8, 0.1, 0.1}, []float64{0.1, 0.2, 0.7})
+		g.ConvertToLogProbs()
+		if _, e := g.BaumWelch(corpus, BaumWelchOptions{MaxIter: 3, Workers: workers}); e != nil {
+			t.Fatal(e)
+		}
+		_, w := g.IsConnected("a", "b")
+		weights = append(weights, w)
+	}
+	if !Comparef64(weights[0], weights[1], 1e-9) {
+		t.Fatalf("results differ: %v", weights)
+	}
+}
+
+func TestBaumWelchErrors(t *testing.T) {
+
+	g := discreteGraph([]float64{0.5, 0.5}, []float64{0.8, 0.1, 0.1}, []float64{0.1, 0.2, 0.7})
+	g.Meta().Weights = LinearWeight
+	if _, e := g.BaumWelch([][]interface{}{{0}}, BaumWelchOptions{}); e == nil {
+		t.Fatal("expected weight kind error")
+	}
+	g.Meta().Weights = UnknownWeight
+	g.ConvertToLogProbs()
+	if _, e := g.BaumWelch([][]interface{}{{}}, BaumWelchOptions{}); e != ErrZeroLikelihood {
+		t.Fatalf("expected ErrZeroLikelihood, got [%v]", e)
+	}
+}
@@ -0,0 +1,463 @@
+// Copyright (c) 2013 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graph
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+)
+
+// Binary graph format.
+//
+// All integers are little endian. The file starts with a fixed size header:
+//
+//   magic      [4]byte  "AKGB"
+//   version    uint32
+//   numNodes   uint64
+//   numArcs    uint64
+//   keyBytes   uint64   size of the key blob
+//   valueBytes uint64   size of the value blob
+//   metaBytes  uint64   size of the metadata
+//
+// followed by these sections:
+//
+//   keyOffsets   [numNodes+1]uint64  offsets into the key blob
+//   keys         [keyBytes]byte      node keys sorted alphabetically
+//   rowPtr       [numNodes+1]uint64  CSR row pointers
+//   colIdx       [numArcs]uint32     CSR column indices, sorted within a row
+//   weights      [numArcs]float64    IEEE 754 bits
+//   valueOffsets [numNodes+1]uint64  offsets into the value blob
+//   values       [valueBytes]byte    encoded node values
+//   meta         [metaBytes]byte     JSON encoded graph metadata
+//
+// Each value starts with a type tag. Strings, integers, floats and
+// booleans are encoded directly; other types are gob encoded and must
+// be registered with gob.Register.
+
+const (
+	binaryMagic   = "AKGB"
+	binaryVersion = 1
+	binaryHeader  = 48
+)
+
+// Value type tags.
+const (
+	tagNil byte = iota
+	tagString
+	tagInt
+	tagFloat
+	tagBool
+	tagGob
+)
+
+var (
+	// ErrBinaryFormat is returned when the input is not in binary graph format.
+	ErrBinaryFormat = errors.New("graph: invalid binary format")
+)
+
+// WriteBinary writes the graph to an io.Writer in binary format.
+// Keys are sorted and arcs are stored in compressed sparse row format
+// so the result can be used with OpenMapped without decoding.
+func (g *Graph) WriteBinary(w io.Writer) error {
+
+	nodes, index := g.sortedNodes()
+	n := len(nodes)
+
+	keyOff := make([]uint64, n+1)
+	var keys bytes.Buffer
+	valOff := make([]uint64, n+1)
+	var vals bytes.Buffer
+	var nnz int
+	for i, node := range nodes {
+		keys.WriteString(node.key)
+		keyOff[i+1] = uint64(keys.Len())
+		if e := encodeValue(&vals, node.value); e != nil {
+			return fmt.Errorf("cannot encode value of node [%s]: %s", node.key, e)
+		}
+		valOff[i+1] = uint64(vals.Len())
+		nnz += len(node.successors)
+	}
+	meta, e := json.Marshal(g.Meta())
+	if e != nil {
+		return e
+	}
+
+	bw := bufio.NewWriter(w)
+	le := binary.LittleEndian
+	buf := make([]byte, 8)
+	put64 := func(v uint64) {
+		le.PutUint64(buf, v)
+		bw.Write(buf)
+	}
+	put32 := func(v uint32) {
+		le.PutUint32(buf, v)
+		bw.Write(buf[:4])
+	}
+
+	// Header.
+	bw.WriteString(binaryMagic)
+	put32(binaryVersion)
+	put64(uint64(n))
+	put64(uint64(nnz))
+	put64(uint64(keys.Len()))
+	put64(uint64(vals.Len()))
+	put64(uint64(len(meta)))
+
+	// Keys.
+	for _, v := range keyOff {
+		put64(v)
+	}
+	bw.Write(keys.Bytes())
+
+	// Arcs.
+	var ptr uint64
+	put64(0)
+	succ := make([][]*Node, n)
+	for i, node := range nodes {
+		succ[i], _ = sortedSuccessors(node, index)
+		ptr += uint64(len(succ[i]))
+		put64(ptr)
+	}
+	for i := range nodes {
+		for _, s := range succ[i] {
+			put32(uint32(index[s]))
+		}
+	}
+	for i, node := range nodes {
+		for _, s := range succ[i] {
+			put64(math.Float64bits(node.successors[s]))
+		}
+	}
+
+	// Values.
+	for _, v := range valOff {
+		put64(v)
+	}
+	bw.Write(vals.Bytes())
+	bw.Write(meta)
+
+	return bw.Flush()
+}
+
+// ReadBinary reads a graph in binary format.
+func ReadBinary(r io.Reader) (*Graph, error) {
+
+	b, e := ioutil.ReadAll(r)
+	if e != nil {
+		return nil, e
+	}
+	m, e := newMappedGraph(b)
+	if e != nil {
+		return nil, e
+	}
+	return m.Graph()
+}
+
+func encodeValue(buf *bytes.Buffer, v interface{}) error {
+
+	b := make([]byte, binary.MaxVarintLen64)
+	switch x := v.(type) {
+	case nil:
+		buf.WriteByte(tagNil)
+	case string:
+		buf.WriteByte(tagString)
+		buf.WriteString(x)
+	case int:
+		buf.WriteByte(tagInt)
+		buf.Write(b[:binary.PutVarint(b, int64(x))])
+	case float64:
+		buf.WriteByte(tagFloat)
+		binary.LittleEndian.PutUint64(b, math.Float64bits(x))
+		buf.Write(b[:8])
+	case bool:
+		buf.WriteByte(tagBool)
+		if x {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	default:
+		buf.WriteByte(tagGob)
+		return gob.NewEncoder(buf).Encode(&v)
+	}
+	return nil
+}
+
+func decodeValue(b []byte) (interface{}, error) {
+
+	if len(b) == 0 {
+		return nil, ErrBinaryFormat
+	}
+	switch b[0] {
+	case tagNil:
+		return nil, nil
+	case tagString:
+		return string(b[1:]), nil
+	case tagInt:
+		x, k := binary.Varint(b[1:])
+		if k <= 0 {
+			return nil, ErrBinaryFormat
+		}
+		return int(x), nil
+	case tagFloat:
+		if len(b) != 9 {
+			return nil, ErrBinaryFormat
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b[1:])), nil
+	case tagBool:
+		if len(b) != 2 {
+			return nil, ErrBinaryFormat
+		}
+		return b[1] == 1, nil
+	case tagGob:
+		var v interface{}
+		e := gob.NewDecoder(bytes.NewReader(b[1:])).Decode(&v)
+		return v, e
+	}
+	return nil, fmt.Errorf("graph: unknown value type tag [%d]", b[0])
+}
+
+// MappedGraph is a read-only graph backed by data in binary format.
+// Nodes are identified by their index in alphabetical key order. Values
+// are decoded on demand. A MappedGraph created with OpenMapped must be
+// closed to release the memory mapping. It is safe for concurrent use.
+type MappedGraph struct {
+	data    []byte
+	n, nnz  int
+	keyOff  int // section offsets
+	keys    int
+	rowPtr  int
+	colIdx  int
+	weights int
+	valOff  int
+	vals    int
+	meta    *Meta
+	unmap   func() error
+}
+
+// OpenMapped opens a graph file in binary format and maps it into
+// memory. The offset arrays and column indices are validated when the
+// file is opened; keys, weights and values are not read.
+func OpenMapped(path string) (*MappedGraph, error) {
+
+	data, unmap, e := mmapFile(path)
+	if e != nil {
+		return nil, e
+	}
+	m, e := newMappedGraph(data)
+	if e != nil {
+		unmap()
+		return nil, e
+	}
+	m.unmap = unmap
+	return m, nil
+}
+
+func newMappedGraph(data []byte) (*MappedGraph, error) {
+
+	if len(data) < binaryHeader || string(data[:4]) != binaryMagic {
+		return nil, ErrBinaryFormat
+	}
+	le := binary.LittleEndian
+	if v := le.Uint32(data[4:]); v != binaryVersion {
+		return nil, fmt.Errorf("graph: unsupported binary format version [%d], expected [%d]", v, binaryVersion)
+	}
+	n := le.Uint64(data[8:])
+	nnz := le.Uint64(data[16:])
+	keyBytes := le.Uint64(data[24:])
+	valBytes := le.Uint64(data[32:])
+	metaBytes := le.Uint64(data[40:])
+	size := uint64(len(data))
+	if n > size || nnz > size || keyBytes > size || valBytes > size || metaBytes > size {
+		return nil, ErrBinaryFormat
+	}
+
+	m := &MappedGraph{data: data, n: int(n), nnz: int(nnz), meta: &Meta{Attrs: map[string]string{}}}
+	off := binaryHeader
+	m.keyOff = off
+	off += 8 * (m.n + 1)
+	m.keys = off
+	off += int(keyBytes)
+	m.rowPtr = off
+	off += 8 * (m.n + 1)
+	m.colIdx = off
+	off += 4 * m.nnz
+	m.weights = off
+	off += 8 * m.nnz
+	m.valOff = off
+	off += 8 * (m.n + 1)
+	m.vals = off
+	off += int(valBytes)
+	meta := off
+	off += int(metaBytes)
+	if off != len(data) {
+		return nil, ErrBinaryFormat
+	}
+	if metaBytes > 0 {
+		if e := json.Unmarshal(data[meta:], m.meta); e != nil {
+			return nil, ErrBinaryFormat
+		}
+		if m.meta.Attrs == nil {
+			m.meta.Attrs = map[string]string{}
+		}
+	}
+	if !m.checkOffsets(m.keyOff, int(keyBytes)) || !m.checkOffsets(m.rowPtr, m.nnz) ||
+		!m.checkOffsets(m.valOff, int(valBytes)) {
+		return nil, ErrBinaryFormat
+	}
+	for a := 0; a < m.nnz; a++ {
+		if int(binary.LittleEndian.Uint32(m.data[m.colIdx+4*a:])) >= m.n {
+			return nil, ErrBinaryFormat
+		}
+	}
+	return m, nil
+}
+
+// Checks that the offset array that starts at off goes from zero to
+// size and is non-decreasing.
+func (m *MappedGraph) checkOffsets(off, size int) bool {
+
+	le := binary.LittleEndian
+	if le.Uint64(m.data[off:]) != 0 || le.Uint64(m.data[off+8*m.n:]) != uint64(size) {
+		return false
+	}
+	for i := 0; i < m.n; i++ {
+		if le.Uint64(m.data[off+8*i:]) > le.Uint64(m.data[off+8*(i+1):]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Close releases the memory mapping. The MappedGraph must not be used
+// after calling Close.
+func (m *MappedGraph) Close() error {
+	if m.unmap == nil {
+		return nil
+	}
+	e := m.unmap()
+	m.unmap = nil
+	m.data = nil
+	return e
+}
+
+func (m *MappedGraph) u64(off, i int) int {
+	return int(binary.LittleEndian.Uint64(m.data[off+8*i:]))
+}
+
+// Meta returns the graph metadata.
+func (m *MappedGraph) Meta() *Meta {
+	return m.meta
+}
+
+// Len returns the number of nodes.
+func (m *MappedGraph) Len() int {
+	return m.n
+}
+
+// NumArcs returns the number of arcs.
+func (m *MappedGraph) NumArcs() int {
+	return m.nnz
+}
+
+// Key returns the key of node i.
+func (m *MappedGraph) Key(i int) string {
+	return string(m.data[m.keys+m.u64(m.keyOff, i) : m.keys+m.u64(m.keyOff, i+1)])
+}
+
+// Index returns the index of the node with key. Returns false if key
+// is not found.
+func (m *MappedGraph) Index(key string) (int, bool) {
+	i := sort.Search(m.n, func(i int) bool { return m.Key(i) >= key })
+	if i < m.n && m.Key(i) == key {
+		return i, true
+	}
+	return 0, false
+}
+
+// Value decodes and returns the value of node i.
+func (m *MappedGraph) Value(i int) (interface{}, error) {
+	return decodeValue(m.data[m.vals+m.u64(m.valOff, i) : m.vals+m.u64(m.valOff, i+1)])
+}
+
+// NumSuccessors returns the number of outbound arcs of node i.
+func (m *MappedGraph) NumSuccessors(i int) int {
+	return m.u64(m.rowPtr, i+1) - m.u64(m.rowPtr, i)
+}
+
+// Successor returns the index of the destination node and the weight
+// of the k-th outbound arc of node i. Arcs are sorted by destination.
+func (m *MappedGraph) Successor(i, k int) (to int, weight float64) {
+	a := m.u64(m.rowPtr, i) + k
+	to = int(binary.LittleEndian.Uint32(m.data[m.colIdx+4*a:]))
+	weight = math.Float64frombits(binary.LittleEndian.Uint64(m.data[m.weights+8*a:]))
+	return
+}
+
+// IsConnected returns true and the arc weight if arc exists.
+// Returns false if one or both keys are invalid or if there is no arc between the nodes.
+func (m *MappedGraph) IsConnected(from string, to string) (exists bool, weight float64) {
+
+	i, ok := m.Index(from)
+	if !ok {
+		return
+	}
+	j, ok := m.Index(to)
+	if !ok {
+		return
+	}
+	ns := m.NumSuccessors(i)
+	k := sort.Search(ns, func(k int) bool {
+		s, _ := m.Successor(i, k)
+		return s >= j
+	})
+	if k < ns {
+		s, w := m.Successor(i, k)
+		if s == j {
+			return true, w
+		}
+	}
+	return
+}
+
+// Graph decodes the mapped data into a new *Graph.
+func (m *MappedGraph) Graph() (*Graph, error) {
+
+	g := New()
+	meta := *m.meta
+	meta.Attrs = make(map[string]string, len(m.meta.Attrs))
+	for k, v := range m.meta.Attrs {
+		meta.Attrs[k] = v
+	}
+	g.meta = &meta
+	nodes := make([]*Node, m.n)
+	for i := 0; i < m.n; i++ {
+		v, e := m.Value(i)
+		if e != nil {
+			return nil, e
+		}
+		nodes[i] = g.Set(m.Key(i), v)
+	}
+	if g.Len() != m.n {
+		return nil, ErrDuplicateKey
+	}
+	for i, node := range nodes {
+		for k := 0; k < m.NumSuccessors(i); k++ {
+			j, w := m.Successor(i, k)
+			node.successors[nodes[j]] = w
+		}
+	}
+	return g, nil
+}
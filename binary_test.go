@@ -0,0 +1,126 @@
+// Copyright (c) 2013 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graph
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBinary(t *testing.T) {
+
+	g0 := sampleGraph(t)
+	g0.Set("5", 2.5)
+	g0.Set("6", true)
+	g0.Set("7", nil)
+	g0.Set("8", FstState{Start: true})
+	g0.Connect("5", "6", -1.25)
+	g0.Meta().Name = "sample"
+	g0.Meta().Weights = CostWeight
+	g0.Meta().Attrs["source"] = "test"
+
+	buf := new(bytes.Buffer)
+	if e := g0.WriteBinary(buf); e != nil {
+		t.Fatal(e)
+	}
+	g1, e := ReadBinary(buf)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if m := g1.Meta(); m.Name != "sample" || m.Weights != CostWeight || m.Attrs["source"] != "test" {
+		t.Fatalf("unexpected metadata: %+v", m)
+	}
+	n, _ := g1.Get("8")
+	if st, ok := n.Value().(FstState); !ok || !st.Start {
+		t.Fatalf("unexpected value for gob encoded node: %+v", n.Value())
+	}
+	g0.Delete("8")
+	g1.Delete("8")
+	if e := compareGraphs(g0, g1); e != nil {
+		t.Fatal(e)
+	}
+
+	// Not a graph.
+	if _, e := ReadBinary(bytes.NewReader([]byte("hello"))); e != ErrBinaryFormat {
+		t.Fatalf("expected ErrBinaryFormat, got [%v]", e)
+	}
+}
+
+func TestBinaryCorrupt(t *testing.T) {
+
+	buf := new(bytes.Buffer)
+	if e := sampleGraph(t).WriteBinary(buf); e != nil {
+		t.Fatal(e)
+	}
+	data := buf.Bytes()
+
+	// Key offset of the second node.
+	b := append([]byte(nil), data...)
+	b[binaryHeader+8] = 0xff
+	if _, e := ReadBinary(bytes.NewReader(b)); e != ErrBinaryFormat {
+		t.Fatalf("expected ErrBinaryFormat, got [%v]", e)
+	}
+
+	// Unsupported version.
+	b = append([]byte(nil), data...)
+	binary.LittleEndian.PutUint32(b[4:], binaryVersion+1)
+	if _, e := ReadBinary(bytes.NewReader(b)); e == nil || e == ErrBinaryFormat {
+		t.Fatalf("expected version error, got [%v]", e)
+	}
+
+	// Corrupted files must not cause a panic.
+	for k := range data {
+		b := append([]byte(nil), data...)
+		b[k] ^= 0xff
+		ReadBinary(bytes.NewReader(b))
+	}
+}
+
+func TestOpenMapped(t *testing.T) {
+
+	g := sampleGraph(t)
+	fn := filepath.Join(t.TempDir(), "graph.bin")
+	f, e := os.Create(fn)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if e := g.WriteBinary(f); e != nil {
+		t.Fatal(e)
+	}
+	f.Close()
+
+	m, e := OpenMapped(fn)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer m.Close()
+
+	if m.Len() != 4 || m.NumArcs() != 4 {
+		t.Fatalf("expected 4 nodes and 4 arcs, got [%d] and [%d]", m.Len(), m.NumArcs())
+	}
+	for _, from := range []string{"1", "2", "3", "4"} {
+		for _, to := range []string{"1", "2", "3", "4"} {
+			ok0, w0 := g.IsConnected(from, to)
+			ok1, w1 := m.IsConnected(from, to)
+			if ok0 != ok1 || w0 != w1 {
+				t.Fatalf("arc mismatch from [%s] to [%s]: [%t] [%f] vs. [%t] [%f]", from, to, ok0, w0, ok1, w1)
+			}
+		}
+	}
+	i, ok := m.Index("3")
+	if !ok {
+		t.Fatalf("key [3] not found")
+	}
+	if v, e := m.Value(i); e != nil || v != "abc" {
+		t.Fatalf("expected value [abc], got [%v] [%v]", v, e)
+	}
+	if _, ok := m.Index("9"); ok {
+		t.Fatalf("unexpected key [9]")
+	}
+}
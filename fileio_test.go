@@ -10,9 +10,11 @@ package graph
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"launchpad.net/goyaml"
@@ -111,3 +113,64 @@ arcs:
   "4":
     "2": 3
 `
+
+func TestIOVersion(t *testing.T) {
+
+	// Files without version are upgraded.
+	g0 := sampleGraph(t)
+	g1, e := ReadYAML(strings.NewReader(graphData))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if e := compareGraphs(g0, g1); e != nil {
+		t.Fatal(e)
+	}
+
+	// Written files have version and metadata.
+	b, e := json.Marshal(g0)
+	if e != nil {
+		t.Fatal(e)
+	}
+	gio := &GraphIO{}
+	if e := json.Unmarshal(b, gio); e != nil {
+		t.Fatal(e)
+	}
+	if gio.Version != IOVersion || gio.Meta == nil || gio.Meta.Creator != IOCreator || !gio.Meta.Directed {
+		t.Fatalf("unexpected header: version [%d], meta [%+v]", gio.Version, gio.Meta)
+	}
+
+	// Files that are too new are rejected.
+	newer := fmt.Sprintf(`{"version":%d,"nodes":{"a":1},"arcs":{}}`, IOVersion+1)
+	e = json.Unmarshal([]byte(newer), New())
+	if _, ok := e.(*VersionError); !ok {
+		t.Fatalf("expected VersionError, got [%v]", e)
+	}
+	_, e = ReadYAML(strings.NewReader(fmt.Sprintf("version: %d\nnodes:\n  a: 1\n", IOVersion+1)))
+	if _, ok := e.(*VersionError); !ok {
+		t.Fatalf("expected VersionError, got [%v]", e)
+	}
+}
+
+func TestMigration(t *testing.T) {
+
+	// Hook to upgrade node values in version 0 files.
+	old := RegisterMigration(0, nil)
+	RegisterMigration(0, func(gio *GraphIO) error {
+		for k, v := range gio.Nodes {
+			if s, ok := v.(string); ok {
+				gio.Nodes[k] = "v0:" + s
+			}
+		}
+		return old(gio)
+	})
+	defer RegisterMigration(0, old)
+
+	g := New()
+	if e := json.Unmarshal([]byte(`{"nodes":{"a":"x","b":"y"},"arcs":{"a":{"b":1}}}`), g); e != nil {
+		t.Fatal(e)
+	}
+	a, _ := g.Get("a")
+	if a.Value() != "v0:x" {
+		t.Fatalf("expected migrated value, got [%v]", a.Value())
+	}
+}
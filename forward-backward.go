@@ -0,0 +1,350 @@
+// Copyright (c) 2013 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graph
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrZeroLikelihood is returned when no path in the graph can generate
+// the observation sequence.
+var ErrZeroLikelihood = errors.New("graph: observation sequence has zero likelihood")
+
+// ForwardBackward computes the likelihood of an observation sequence
+// and the state and arc posteriors using the forward-backward algorithm.
+// (see http://en.wikipedia.org/wiki/Forward-backward_algorithm)
+// It uses the same model as the Decoder: node values must implement the
+// Viterbier interface, arc weights are log probabilities, null nodes
+// don't consume observations and paths that reach the end node are
+// discarded. Unlike the Decoder, scores of alternative paths are added
+// instead of maximized.
+// The graph must not be modified while the engine is in use.
+type ForwardBackward struct {
+	graph *Graph
+	// Nodes sorted by key. Tables are indexed using this order.
+	nodes    []*Node
+	start    int
+	end      int
+	emitting []bool
+	arcs     [][]fbArc
+	// Null nodes in topological order.
+	nullOrder []int
+}
+
+type fbArc struct {
+	to int
+	w  float64
+}
+
+// Posteriors holds the result of the forward-backward algorithm.
+// Tables are indexed by observation and by node, following the order
+// in Keys. Entries of null nodes are -Inf in Alpha and Beta and zero in
+// Gamma.
+type Posteriors struct {
+	// Node keys.
+	Keys []string
+	// Forward log probabilities.
+	Alpha [][]float64
+	// Backward log probabilities.
+	Beta [][]float64
+	// Total log likelihood of the observation sequence.
+	LogLikelihood float64
+	// State occupancy probabilities.
+	Gamma [][]float64
+	// Expected number of times each arc is traversed.
+	ArcCounts map[*Node]map[*Node]float64
+}
+
+// NewForwardBackward creates a forward-backward engine. The graph must
+// have exactly one start and one end node, arc weights must be log
+// probabilities and null nodes must not form cycles.
+func NewForwardBackward(g *Graph) (*ForwardBackward, error) {
+
+	starts := g.StartNodes()
+	if len(starts) != 1 {
+		return nil, fmt.Errorf("graph must have exactly one start node. Found: %d", len(starts))
+	}
+	ends := g.EndNodes()
+	if len(ends) != 1 {
+		return nil, fmt.Errorf("graph must have exactly one end node. Found: %d", len(ends))
+	}
+	e := g.checkViterbier()
+	if e != nil {
+		return nil, e
+	}
+	e = g.checkWeights("ForwardBackward", LogWeight)
+	if e != nil {
+		return nil, e
+	}
+
+	nodes, index := g.sortedNodes()
+	fb := &ForwardBackward{
+		graph:    g,
+		nodes:    nodes,
+		start:    index[starts[0]],
+		end:      index[ends[0]],
+		emitting: make([]bool, len(nodes)),
+		arcs:     make([][]fbArc, len(nodes)),
+	}
+	for i, node := range nodes {
+		fb.emitting[i] = i != fb.end && !node.value.(Viterbier).IsNull()
+		succ, idx := sortedSuccessors(node, index)
+		for k, n := range succ {
+			// Paths that reach the end node are discarded.
+			if idx[k] == fb.end {
+				continue
+			}
+			fb.arcs[i] = append(fb.arcs[i], fbArc{to: idx[k], w: node.successors[n]})
+		}
+	}
+
+	null := make([]bool, len(nodes))
+	for i := range nodes {
+		null[i] = !fb.emitting[i]
+	}
+	fb.nullOrder, e = sortNulls(nodes, null, func(i int, visit func(int)) {
+		for _, a := range fb.arcs[i] {
+			visit(a.to)
+		}
+	})
+	if e != nil {
+		return nil, e
+	}
+	return fb, nil
+}
+
+// Returns a slice of length n filled with -Inf.
+func logZeros(n int) []float64 {
+	v := make([]float64, n)
+	for i := range v {
+		v[i] = math.Inf(-1)
+	}
+	return v
+}
+
+// Returns the emission scores of every emitting node for each observation.
+func (fb *ForwardBackward) scores(obs []interface{}) [][]float64 {
+
+	sc := make([][]float64, len(obs))
+	for t, o := range obs {
+		sc[t] = logZeros(len(fb.nodes))
+		for i, node := range fb.nodes {
+			if fb.emitting[i] {
+				sc[t][i] = node.value.(Viterbier).Score(o)
+			}
+		}
+	}
+	return sc
+}
+
+// Propagates scores from emitting nodes in src through null nodes to the
+// emitting nodes of the next observation. Returns the accumulated score
+// of the null nodes and the score of the emitting nodes, including the
+// emission score sc. If src is nil, propagation starts at the start node.
+func (fb *ForwardBackward) forwardStep(src, sc []float64) (null, dst []float64) {
+
+	n := len(fb.nodes)
+	null = logZeros(n)
+	dst = logZeros(n)
+	add := func(from int, v float64) {
+		for _, a := range fb.arcs[from] {
+			x := v + a.w
+			if fb.emitting[a.to] {
+				dst[a.to] = logAdd(dst[a.to], x)
+			} else {
+				null[a.to] = logAdd(null[a.to], x)
+			}
+		}
+	}
+	switch {
+	case src == nil && fb.emitting[fb.start]:
+		add(fb.start, 0)
+	case src == nil:
+		null[fb.start] = 0
+	default:
+		for i, v := range src {
+			if fb.emitting[i] && !math.IsInf(v, -1) {
+				add(i, v)
+			}
+		}
+	}
+	for _, i := range fb.nullOrder {
+		if !math.IsInf(null[i], -1) {
+			add(i, null[i])
+		}
+	}
+	for i := range dst {
+		if fb.emitting[i] {
+			dst[i] += sc[i]
+		}
+	}
+	return
+}
+
+// Computes the backward scores of all nodes given the backward scores of
+// the emitting nodes for the next observation and their emission scores.
+func (fb *ForwardBackward) backwardStep(next, sc []float64) (null, cur []float64) {
+
+	n := len(fb.nodes)
+	null = logZeros(n)
+	cur = logZeros(n)
+	sum := func(from int) float64 {
+		s := math.Inf(-1)
+		for _, a := range fb.arcs[from] {
+			if fb.emitting[a.to] {
+				s = logAdd(s, a.w+sc[a.to]+next[a.to])
+			} else {
+				s = logAdd(s, a.w+null[a.to])
+			}
+		}
+		return s
+	}
+	for k := len(fb.nullOrder) - 1; k >= 0; k-- {
+		i := fb.nullOrder[k]
+		null[i] = sum(i)
+	}
+	for i := range cur {
+		if fb.emitting[i] {
+			cur[i] = sum(i)
+		}
+	}
+	return
+}
+
+// Forward returns the forward log probabilities and the total log
+// likelihood of the observation sequence.
+func (fb *ForwardBackward) Forward(obs []interface{}) (alpha [][]float64, logLikelihood float64) {
+
+	alpha, _ = fb.forward(obs, fb.scores(obs))
+	return alpha, fb.total(alpha)
+}
+
+func (fb *ForwardBackward) forward(obs []interface{}, sc [][]float64) (alpha, null [][]float64) {
+
+	alpha = make([][]float64, len(obs))
+	null = make([][]float64, len(obs))
+	var prev []float64
+	for t := range obs {
+		null[t], alpha[t] = fb.forwardStep(prev, sc[t])
+		prev = alpha[t]
+	}
+	return
+}
+
+// Returns the total log likelihood.
+func (fb *ForwardBackward) total(alpha [][]float64) float64 {
+
+	if len(alpha) == 0 {
+		return math.Inf(-1)
+	}
+	ll := math.Inf(-1)
+	for _, v := range alpha[len(alpha)-1] {
+		ll = logAdd(ll, v)
+	}
+	return ll
+}
+
+// Backward returns the backward log probabilities.
+func (fb *ForwardBackward) Backward(obs []interface{}) (beta [][]float64) {
+
+	beta, _ = fb.backward(obs, fb.scores(obs))
+	return
+}
+
+func (fb *ForwardBackward) backward(obs []interface{}, sc [][]float64) (beta, null [][]float64) {
+
+	T := len(obs)
+	beta = make([][]float64, T)
+	null = make([][]float64, T)
+	if T == 0 {
+		return
+	}
+	beta[T-1] = logZeros(len(fb.nodes))
+	for i := range fb.nodes {
+		if fb.emitting[i] {
+			beta[T-1][i] = 0
+		}
+	}
+	// null[t] holds the backward scores of null nodes visited before
+	// observation t.
+	for t := T - 1; t > 0; t-- {
+		null[t], beta[t-1] = fb.backwardStep(beta[t], sc[t])
+	}
+	null[0], _ = fb.backwardStep(beta[0], sc[0])
+	return
+}
+
+// Compute runs the forward-backward algorithm. Returns ErrZeroLikelihood
+// if the observation sequence can't be generated by the graph.
+func (fb *ForwardBackward) Compute(obs []interface{}) (*Posteriors, error) {
+
+	sc := fb.scores(obs)
+	alpha, nullF := fb.forward(obs, sc)
+	ll := fb.total(alpha)
+	if math.IsInf(ll, -1) || math.IsNaN(ll) {
+		return nil, ErrZeroLikelihood
+	}
+	beta, nullB := fb.backward(obs, sc)
+
+	p := &Posteriors{
+		Keys:          make([]string, len(fb.nodes)),
+		Alpha:         alpha,
+		Beta:          beta,
+		LogLikelihood: ll,
+		Gamma:         make([][]float64, len(obs)),
+		ArcCounts:     make(map[*Node]map[*Node]float64),
+	}
+	for i, node := range fb.nodes {
+		p.Keys[i] = node.key
+	}
+	for t := range obs {
+		p.Gamma[t] = make([]float64, len(fb.nodes))
+		for i := range fb.nodes {
+			if fb.emitting[i] {
+				p.Gamma[t][i] = math.Exp(alpha[t][i] + beta[t][i] - ll)
+			}
+		}
+	}
+
+	// Arcs traversed before observation t start at an emitting node of
+	// observation t-1 (or at the start node) or at a null node.
+	for t := range obs {
+		for i := range fb.nodes {
+			var from float64
+			switch {
+			case !fb.emitting[i]:
+				from = nullF[t][i]
+			case t > 0:
+				from = alpha[t-1][i]
+			case i == fb.start:
+				from = 0
+			default:
+				continue
+			}
+			if math.IsInf(from, -1) {
+				continue
+			}
+			for _, a := range fb.arcs[i] {
+				to := nullB[t][a.to]
+				if fb.emitting[a.to] {
+					to = sc[t][a.to] + beta[t][a.to]
+				}
+				c := math.Exp(from + a.w + to - ll)
+				if c == 0 {
+					continue
+				}
+				src, dst := fb.nodes[i], fb.nodes[a.to]
+				if p.ArcCounts[src] == nil {
+					p.ArcCounts[src] = make(map[*Node]float64)
+				}
+				p.ArcCounts[src][dst] += c
+			}
+		}
+	}
+	return p, nil
+}
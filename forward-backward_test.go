@@ -0,0 +1,206 @@
+// Copyright (c) 2013 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graph
+
+import (
+	"math"
+	"testing"
+)
+
+// Computes the likelihood by enumerating all paths.
+func bruteForceLikelihood(g *Graph, obs []interface{}) float64 {
+
+	end := g.EndNodes()[0]
+	total := math.Inf(-1)
+	var rec func(node *Node, t int, acc float64)
+	rec = func(node *Node, t int, acc float64) {
+		if t == len(obs) && !node.value.(Viterbier).IsNull() {
+			total = logAdd(total, acc)
+		}
+		for n, w := range node.successors {
+			v := n.value.(Viterbier)
+			switch {
+			case n == end:
+			case v.IsNull():
+				rec(n, t, acc+w)
+			case t < len(obs):
+				rec(n, t+1, acc+w+v.Score(obs[t]))
+			}
+		}
+	}
+	rec(g.StartNodes()[0], 0, 0)
+	return total
+}
+
+// Node value with a scoring function.
+type fvalue struct {
+	null bool
+	f    ScoreFunc
+}
+
+func (v fvalue) Score(o interface{}) float64 { return v.f(o) }
+func (v fvalue) IsNull() bool                { return v.null }
+
+// Returns a scoring function that peaks at observation k.
+func peakScore(k float64) ScoreFunc {
+	return func(o interface{}) float64 {
+		return -k * math.Abs(float64(o.(int))-k)
+	}
+}
+
+// Graph with null nodes between emitting nodes.
+func nullGraph() *Graph {
+
+	g := New()
+	g.Set("start", fvalue{null: true})
+	g.Set("a", fvalue{f: peakScore(1)})
+	g.Set("n1", fvalue{null: true})
+	g.Set("n2", fvalue{null: true})
+	g.Set("b", fvalue{f: peakScore(2)})
+	g.Set("end", fvalue{null: true})
+	g.Connect("start", "a", 0.6)
+	g.Connect("start", "n1", 0.4)
+	g.Connect("a", "a", 0.5)
+	g.Connect("a", "n1", 0.5)
+	g.Connect("n1", "n2", 0.3)
+	g.Connect("n1", "b", 0.7)
+	g.Connect("n2", "a", 1)
+	g.Connect("b", "b", 0.5)
+	g.Connect("b", "n2", 0.3)
+	g.Connect("b", "end", 0.2)
+	g.ConvertToLogProbs()
+	return g
+}
+
+// Left-to-right graph without null nodes between emitting nodes.
+func chainGraph() *Graph {
+
+	g := New()
+	g.Set("s0", fvalue{null: true})
+	g.Set("s1", fvalue{f: peakScore(1)})
+	g.Set("s2", fvalue{f: peakScore(2)})
+	g.Set("s3", fvalue{f: peakScore(3)})
+	g.Set("s4", fvalue{null: true})
+	g.Connect("s0", "s1", 1)
+	g.Connect("s1", "s1", 0.4)
+	g.Connect("s1", "s2", 0.5)
+	g.Connect("s1", "s3", 0.1)
+	g.Connect("s2", "s2", 0.5)
+	g.Connect("s2", "s3", 0.5)
+	g.Connect("s3", "s3", 0.6)
+	g.Connect("s3", "s4", 0.4)
+	g.ConvertToLogProbs()
+	return g
+}
+
+func TestForwardBackward(t *testing.T) {
+
+	obs := []interface{}{1, 2, 2, 1, 2}
+	for _, tc := range []struct {
+		g   *Graph
+		obs []interface{}
+	}{{nullGraph(), obs}, {chainGraph(), obs}} {
+
+		fb, e := NewForwardBackward(tc.g)
+		if e != nil {
+			t.Fatal(e)
+		}
+		p, e := fb.Compute(tc.obs)
+		if e != nil {
+			t.Fatal(e)
+		}
+		expected := bruteForceLikelihood(tc.g, tc.obs)
+		if !Comparef64(p.LogLikelihood, expected, 1e-9) {
+			t.Fatalf("expected log likelihood [%f], got [%f]", expected, p.LogLikelihood)
+		}
+		_, ll := fb.Forward(tc.obs)
+		if !Comparef64(ll, expected, 1e-9) {
+			t.Fatalf("expected log likelihood [%f], got [%f]", expected, ll)
+		}
+
+		// Posteriors sum to one.
+		for k, gamma := range p.Gamma {
+			var sum float64
+			for _, v := range gamma {
+				sum += v
+			}
+			if !Comparef64(sum, 1, 1e-9) {
+				t.Fatalf("gamma at [%d] sums to [%f]", k, sum)
+			}
+		}
+
+		// Arcs into emitting nodes are traversed once per observation.
+		// Arcs leaving the start node are traversed once.
+		var into, fromStart float64
+		start := tc.g.StartNodes()[0]
+		for from, m := range p.ArcCounts {
+			for to, c := range m {
+				if !to.value.(Viterbier).IsNull() {
+					into += c
+				}
+				if from == start {
+					fromStart += c
+				}
+			}
+		}
+		if !Comparef64(into, float64(len(tc.obs)), 1e-9) {
+			t.Fatalf("expected [%d] transitions, got [%f]", len(tc.obs), into)
+		}
+		if !Comparef64(fromStart, 1, 1e-9) {
+			t.Fatalf("expected one transition from start, got [%f]", fromStart)
+		}
+
+		// Viterbi score is a lower bound.
+		dec, e := NewDecoder(tc.g)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if best := dec.Decode(tc.obs); best.Score > p.LogLikelihood+1e-9 {
+			t.Fatalf("viterbi score [%f] greater than likelihood [%f]", best.Score, p.LogLikelihood)
+		}
+	}
+}
+
+func TestForwardBackwardBeta(t *testing.T) {
+
+	g := nullGraph()
+	obs := []interface{}{2, 1, 2}
+	fb, e := NewForwardBackward(g)
+	if e != nil {
+		t.Fatal(e)
+	}
+	alpha, ll := fb.Forward(obs)
+	beta := fb.Backward(obs)
+
+	// sum_i alpha[t][i] beta[t][i] is the likelihood for every t.
+	for k := range obs {
+		s := math.Inf(-1)
+		for i := range alpha[k] {
+			s = logAdd(s, alpha[k][i]+beta[k][i])
+		}
+		if !Comparef64(s, ll, 1e-9) {
+			t.Fatalf("t=%d: expected [%f], got [%f]", k, ll, s)
+		}
+	}
+}
+
+func TestForwardBackwardErrors(t *testing.T) {
+
+	g := nullGraph()
+	g.Connect("n2", "n1", 0)
+	if _, e := NewForwardBackward(g); e == nil {
+		t.Fatal("expected null cycle error")
+	}
+
+	g = nullGraph()
+	fb, e := NewForwardBackward(g)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if _, e = fb.Compute(nil); e != ErrZeroLikelihood {
+		t.Fatalf("expected ErrZeroLikelihood, got [%v]", e)
+	}
+}
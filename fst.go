@@ -0,0 +1,454 @@
+// Copyright (c) 2013 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graph
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FstEndKey is the default key of the end node created by ReadFstText.
+const FstEndKey = "</fst>"
+
+func init() {
+	gob.Register(FstState{})
+}
+
+// FstLabel holds the input and output labels of an arc.
+type FstLabel struct {
+	In  string `json:"in"`
+	Out string `json:"out"`
+}
+
+// FstState is the node value used to represent a state of a finite
+// state transducer. Arc labels are indexed by the key of the
+// destination node.
+type FstState struct {
+	Start bool                `json:"start,omitempty"`
+	Arcs  map[string]FstLabel `json:"arcs,omitempty"`
+}
+
+// FstOptions configures the conversion between graphs and the AT&T FSM
+// text format used by OpenFst and Kaldi. A nil *FstOptions is valid and
+// uses default values.
+type FstOptions struct {
+	// Input, output and state symbol tables. If set, numeric ids in the
+	// text file are mapped to symbols and back.
+	ISyms, OSyms, SSyms *SymbolTable
+	// Key of the end node. Final states are connected to the end node
+	// using the final weight. Defaults to FstEndKey.
+	EndKey string
+	// If true, arcs have a single label (src dst label [weight]).
+	Acceptor bool
+}
+
+func (opt *FstOptions) endKey() string {
+	if opt == nil || opt.EndKey == "" {
+		return FstEndKey
+	}
+	return opt.EndKey
+}
+
+// ReadFstText reads a finite state machine in AT&T text format and
+// returns the corresponding graph. Lines are either arcs
+// (src dst ilabel olabel [weight]) or final states (state [weight]).
+// The source state of the first line is the start state. Each state
+// becomes a node whose value is of type FstState. Final states are
+// connected to an extra end node. Weights are copied as is, in OpenFst
+// they are usually costs (negative log probabilities) so the weight kind
+// is set to CostWeight; a missing weight is zero. Because a graph has at most one arc between two nodes,
+// parallel arcs are merged keeping the one with the lowest weight.
+func ReadFstText(r io.Reader, opt *FstOptions) (*Graph, error) {
+
+	if opt == nil {
+		opt = &FstOptions{}
+	}
+	g := New()
+	g.Meta().Weights = CostWeight
+	states := map[string]FstState{}
+	var order []string
+	arcs := map[[2]string]float64{}
+	finals := map[string]float64{}
+
+	state := func(id string) (string, error) {
+		key := id
+		if opt.SSyms != nil {
+			var e error
+			key, e = opt.SSyms.symbol(id)
+			if e != nil {
+				return "", e
+			}
+		}
+		if _, ok := states[key]; !ok {
+			states[key] = FstState{Start: len(order) == 0, Arcs: map[string]FstLabel{}}
+			order = append(order, key)
+		}
+		return key, nil
+	}
+
+	nl := 4
+	if opt.Acceptor {
+		nl = 3
+	}
+	sc := bufio.NewScanner(r)
+	var line int
+	for sc.Scan() {
+		line++
+		f := strings.Fields(sc.Text())
+		if len(f) == 0 {
+			continue
+		}
+		var w float64
+		var e error
+		switch {
+		case len(f) <= 2:
+			// Final state.
+			if len(f) == 2 {
+				w, e = strconv.ParseFloat(f[1], 64)
+				if e != nil {
+					return nil, fmt.Errorf("fst line %d: invalid weight: %s", line, e)
+				}
+			}
+			key, e := state(f[0])
+			if e != nil {
+				return nil, fmt.Errorf("fst line %d: %s", line, e)
+			}
+			finals[key] = w
+		case len(f) == nl || len(f) == nl+1:
+			// Arc.
+			if len(f) == nl+1 {
+				w, e = strconv.ParseFloat(f[nl], 64)
+				if e != nil {
+					return nil, fmt.Errorf("fst line %d: invalid weight: %s", line, e)
+				}
+			}
+			from, e := state(f[0])
+			if e != nil {
+				return nil, fmt.Errorf("fst line %d: %s", line, e)
+			}
+			to, e := state(f[1])
+			if e != nil {
+				return nil, fmt.Errorf("fst line %d: %s", line, e)
+			}
+			il := f[2]
+			ol := il
+			if !opt.Acceptor {
+				ol = f[3]
+			}
+			if opt.ISyms != nil {
+				if il, e = opt.ISyms.symbol(il); e != nil {
+					return nil, fmt.Errorf("fst line %d: %s", line, e)
+				}
+			}
+			if opt.OSyms != nil {
+				if ol, e = opt.OSyms.symbol(ol); e != nil {
+					return nil, fmt.Errorf("fst line %d: %s", line, e)
+				}
+			}
+			if old, ok := arcs[[2]string{from, to}]; ok && old <= w {
+				continue
+			}
+			states[from].Arcs[to] = FstLabel{In: il, Out: ol}
+			arcs[[2]string{from, to}] = w
+		default:
+			return nil, fmt.Errorf("fst line %d: unexpected number of fields [%d]", line, len(f))
+		}
+	}
+	if e := sc.Err(); e != nil {
+		return nil, e
+	}
+
+	if _, ok := states[opt.endKey()]; ok {
+		return nil, fmt.Errorf("fst state key [%s] conflicts with end key", opt.endKey())
+	}
+	for _, key := range order {
+		g.Set(key, states[key])
+	}
+	if len(finals) > 0 {
+		g.Set(opt.endKey(), FstState{})
+	}
+	for a, w := range arcs {
+		g.Connect(a[0], a[1], w)
+	}
+	for key, w := range finals {
+		g.Connect(key, opt.endKey(), w)
+	}
+	return g, nil
+}
+
+// WriteFstText writes the graph in AT&T text format. The start state is
+// the node whose FstState value has Start set or, if there is none, the
+// only start node of the graph. Arcs into the end node are written as
+// final states. Labels are taken from FstState values; for other node
+// values both labels are set to the key of the destination node. Node
+// keys are used as state ids when they are all integers, otherwise
+// states are numbered in key order starting with zero for the start
+// state. Use a state symbol table to control the numbering. Weights are
+// written as costs; weights of a known kind other than CostWeight are
+// converted (see ConvertWeights) and weights of unknown kind are written
+// as is.
+func (g *Graph) WriteFstText(w io.Writer, opt *FstOptions) error {
+
+	if opt == nil {
+		opt = &FstOptions{}
+	}
+	kind := g.Meta().Weights
+	if kind != UnknownWeight {
+		if e := checkConvertible(kind); e != nil {
+			return e
+		}
+	}
+	cost := func(wt float64) float64 {
+		if kind == UnknownWeight {
+			return wt
+		}
+		return fromLog(toLog(wt, kind), CostWeight)
+	}
+	end := g.get(opt.endKey())
+
+	// Find start node.
+	var start *Node
+	for _, node := range g.nodes {
+		if st, ok := node.value.(FstState); ok && st.Start {
+			start = node
+			break
+		}
+	}
+	if start == nil {
+		starts := g.StartNodes()
+		if len(starts) != 1 {
+			return fmt.Errorf("graph must have exactly one start node. Found: %d", len(starts))
+		}
+		start = starts[0]
+	}
+
+	// Assign state ids.
+	nodes, _ := g.sortedNodes()
+	ids := make(map[*Node]string, len(nodes))
+	numeric := true
+	for _, node := range nodes {
+		if node == end {
+			continue
+		}
+		if _, e := strconv.Atoi(node.key); e != nil {
+			numeric = false
+			break
+		}
+	}
+	order := []*Node{start}
+	for _, node := range nodes {
+		if node != start && node != end {
+			order = append(order, node)
+		}
+	}
+	if numeric && opt.SSyms == nil {
+		sort.SliceStable(order[1:], func(a, b int) bool {
+			i, _ := strconv.Atoi(order[a+1].key)
+			j, _ := strconv.Atoi(order[b+1].key)
+			return i < j
+		})
+	}
+	pos := make(map[*Node]int, len(nodes))
+	for k, node := range order {
+		pos[node] = k
+		switch {
+		case opt.SSyms != nil:
+			id, e := opt.SSyms.id(node.key)
+			if e != nil {
+				return e
+			}
+			ids[node] = id
+		case numeric:
+			ids[node] = node.key
+		default:
+			ids[node] = strconv.Itoa(k)
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	var finals []*Node
+	for _, node := range order {
+		st, _ := node.value.(FstState)
+		succ, _ := sortedSuccessors(node, pos)
+		for _, s := range succ {
+			if s == end {
+				finals = append(finals, node)
+				continue
+			}
+			lab, ok := st.Arcs[s.key]
+			if !ok {
+				lab = FstLabel{In: s.key, Out: s.key}
+			}
+			il, ol := lab.In, lab.Out
+			var e error
+			if opt.ISyms != nil {
+				if il, e = opt.ISyms.id(il); e != nil {
+					return e
+				}
+			}
+			if opt.OSyms != nil {
+				if ol, e = opt.OSyms.id(ol); e != nil {
+					return e
+				}
+			}
+			if opt.Acceptor {
+				fmt.Fprintf(bw, "%s\t%s\t%s", ids[node], ids[s], il)
+			} else {
+				fmt.Fprintf(bw, "%s\t%s\t%s\t%s", ids[node], ids[s], il, ol)
+			}
+			if wt := cost(node.successors[s]); wt != 0 {
+				fmt.Fprintf(bw, "\t%s", strconv.FormatFloat(wt, 'g', -1, 64))
+			}
+			fmt.Fprintln(bw)
+		}
+	}
+	for _, node := range finals {
+		fmt.Fprint(bw, ids[node])
+		if wt := cost(node.successors[end]); wt != 0 {
+			fmt.Fprintf(bw, "\t%s", strconv.FormatFloat(wt, 'g', -1, 64))
+		}
+		fmt.Fprintln(bw)
+	}
+	return bw.Flush()
+}
+
+// SymbolTable maps symbols to integer ids as in OpenFst symbol table
+// files (one "symbol id" pair per line).
+type SymbolTable struct {
+	syms map[int]string
+	ids  map[string]int
+}
+
+// NewSymbolTable creates an empty symbol table.
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{syms: map[int]string{}, ids: map[string]int{}}
+}
+
+// Add adds a symbol with the given id. Returns an error if either the
+// symbol or the id already exists with a different mapping.
+func (st *SymbolTable) Add(sym string, id int) error {
+	if s, ok := st.syms[id]; ok && s != sym {
+		return fmt.Errorf("symbol id [%d] already assigned to [%s]", id, s)
+	}
+	if i, ok := st.ids[sym]; ok && i != id {
+		return fmt.Errorf("symbol [%s] already has id [%d]", sym, i)
+	}
+	st.syms[id] = sym
+	st.ids[sym] = id
+	return nil
+}
+
+// Symbol returns the symbol for an id.
+func (st *SymbolTable) Symbol(id int) (sym string, ok bool) {
+	sym, ok = st.syms[id]
+	return
+}
+
+// ID returns the id for a symbol.
+func (st *SymbolTable) ID(sym string) (id int, ok bool) {
+	id, ok = st.ids[sym]
+	return
+}
+
+// Len returns the number of symbols.
+func (st *SymbolTable) Len() int {
+	return len(st.syms)
+}
+
+func (st *SymbolTable) symbol(id string) (string, error) {
+	i, e := strconv.Atoi(id)
+	if e != nil {
+		return "", fmt.Errorf("invalid symbol id [%s]", id)
+	}
+	sym, ok := st.syms[i]
+	if !ok {
+		return "", fmt.Errorf("symbol id [%d] not found in symbol table", i)
+	}
+	return sym, nil
+}
+
+func (st *SymbolTable) id(sym string) (string, error) {
+	i, ok := st.ids[sym]
+	if !ok {
+		return "", fmt.Errorf("symbol [%s] not found in symbol table", sym)
+	}
+	return strconv.Itoa(i), nil
+}
+
+// ReadSymbolTable reads a symbol table in OpenFst text format.
+func ReadSymbolTable(r io.Reader) (*SymbolTable, error) {
+
+	st := NewSymbolTable()
+	sc := bufio.NewScanner(r)
+	var line int
+	for sc.Scan() {
+		line++
+		f := strings.Fields(sc.Text())
+		if len(f) == 0 {
+			continue
+		}
+		if len(f) != 2 {
+			return nil, fmt.Errorf("symbol table line %d: expected 2 fields, got [%d]", line, len(f))
+		}
+		id, e := strconv.Atoi(f[1])
+		if e != nil {
+			return nil, fmt.Errorf("symbol table line %d: invalid id: %s", line, e)
+		}
+		if e := st.Add(f[0], id); e != nil {
+			return nil, fmt.Errorf("symbol table line %d: %s", line, e)
+		}
+	}
+	if e := sc.Err(); e != nil {
+		return nil, e
+	}
+	return st, nil
+}
+
+// ReadSymbolTableFile reads a symbol table file (.syms).
+func ReadSymbolTableFile(fn string) (*SymbolTable, error) {
+
+	f, e := os.Open(fn)
+	if e != nil {
+		return nil, e
+	}
+	defer f.Close()
+	return ReadSymbolTable(f)
+}
+
+// Write writes the symbol table in OpenFst text format sorted by id.
+func (st *SymbolTable) Write(w io.Writer) error {
+
+	ids := make([]int, 0, len(st.syms))
+	for id := range st.syms {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	bw := bufio.NewWriter(w)
+	for _, id := range ids {
+		fmt.Fprintf(bw, "%s\t%d\n", st.syms[id], id)
+	}
+	return bw.Flush()
+}
+
+// WriteFile writes the symbol table to a file.
+func (st *SymbolTable) WriteFile(fn string) error {
+
+	f, e := os.Create(fn)
+	if e != nil {
+		return e
+	}
+	if e = st.Write(f); e != nil {
+		f.Close()
+		return e
+	}
+	return f.Close()
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2013 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graph
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+const fstText = `0	1	a	x	0.5
+0	2	b	y	1.5
+1	2	c	z
+1	2	d	z	2
+2	3	e	e	0.25
+3	2
+`
+
+func TestFstText(t *testing.T) {
+
+	g, e := ReadFstText(strings.NewReader(fstText), nil)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if g.Len() != 5 {
+		t.Fatalf("expected 5 nodes, got [%d]", g.Len())
+	}
+	if k := g.Meta().Weights; k != CostWeight {
+		t.Fatalf("expected cost weights, got [%s]", k)
+	}
+	if ok, w := g.IsConnected("3", FstEndKey); !ok || w != 2 {
+		t.Fatalf("expected final weight 2 for state [3], got [%t] [%f]", ok, w)
+	}
+
+	// Parallel arcs keep the lowest weight.
+	if ok, w := g.IsConnected("1", "2"); !ok || w != 0 {
+		t.Fatalf("expected arc from [1] to [2] with weight 0, got [%t] [%f]", ok, w)
+	}
+	start, _ := g.Get("0")
+	st := start.Value().(FstState)
+	if !st.Start {
+		t.Fatalf("state [0] should be the start state")
+	}
+	if lab := st.Arcs["2"]; lab.In != "b" || lab.Out != "y" {
+		t.Fatalf("unexpected labels for arc from [0] to [2]: %+v", lab)
+	}
+
+	buf := new(bytes.Buffer)
+	if e := g.WriteFstText(buf, nil); e != nil {
+		t.Fatal(e)
+	}
+	t.Logf("fst:\n%s", buf.String())
+	expected := `0	1	a	x	0.5
+0	2	b	y	1.5
+1	2	c	z
+2	3	e	e	0.25
+3	2
+`
+	if buf.String() != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestFstSymbols(t *testing.T) {
+
+	syms, e := ReadSymbolTable(strings.NewReader("<eps> 0\nhello 1\nworld 2\n"))
+	if e != nil {
+		t.Fatal(e)
+	}
+	opt := &FstOptions{ISyms: syms, OSyms: syms, Acceptor: true, EndKey: "end"}
+	g, e := ReadFstText(strings.NewReader("0 1 1\n1 2 2 0.1\n2\n"), opt)
+	if e != nil {
+		t.Fatal(e)
+	}
+	node, _ := g.Get("1")
+	if lab := node.Value().(FstState).Arcs["2"]; lab.In != "world" || lab.Out != "world" {
+		t.Fatalf("unexpected labels: %+v", lab)
+	}
+	if ok, _ := g.IsConnected("2", "end"); !ok {
+		t.Fatalf("missing arc to end node")
+	}
+
+	buf := new(bytes.Buffer)
+	if e := g.WriteFstText(buf, opt); e != nil {
+		t.Fatal(e)
+	}
+	if buf.String() != "0\t1\t1\n1\t2\t2\t0.1\n2\n" {
+		t.Fatalf("unexpected output:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if e := syms.Write(buf); e != nil {
+		t.Fatal(e)
+	}
+	if buf.String() != "<eps>\t0\nhello\t1\nworld\t2\n" {
+		t.Fatalf("unexpected symbol table:\n%s", buf.String())
+	}
+}
+
+func TestWriteFstTextGraph(t *testing.T) {
+
+	// Non numeric keys, labels from destination keys.
+	g := New()
+	g.Set("a", nil)
+	g.Set("b", nil)
+	g.Set("c", nil)
+	g.Connect("a", "b", 1)
+	g.Connect("b", "c", 2)
+
+	buf := new(bytes.Buffer)
+	if e := g.WriteFstText(buf, &FstOptions{EndKey: "c"}); e != nil {
+		t.Fatal(e)
+	}
+	if buf.String() != "0\t1\tb\tb\t1\n1\t2\n" {
+		t.Fatalf("unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestWriteFstTextWeights(t *testing.T) {
+
+	g := New()
+	g.Set("0", nil)
+	g.Set("1", nil)
+	g.Connect("0", "1", math.Log(0.5))
+	g.Connect("1", FstEndKey, 0)
+	g.Meta().Weights = LogWeight
+
+	buf := new(bytes.Buffer)
+	if e := g.WriteFstText(buf, nil); e != nil {
+		t.Fatal(e)
+	}
+	g1, e := ReadFstText(buf, nil)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if ok, w := g1.IsConnected("0", "1"); !ok || !Comparef64(w, -math.Log(0.5), 1e-9) {
+		t.Fatalf("expected cost [%f], got [%t] [%f]", -math.Log(0.5), ok, w)
+	}
+
+	g.Meta().Weights = "other"
+	if e := g.WriteFstText(new(bytes.Buffer), nil); e == nil {
+		t.Fatal("expected error for unknown weight kind")
+	}
+}
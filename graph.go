@@ -12,14 +12,19 @@ import (
 	"bytes"
 	"encoding/gob"
 	"errors"
+	"fmt"
 	"math"
 	"sort"
+
+	"launchpad.net/goyaml"
 )
 
 // The Graph object.
 type Graph struct {
 	// A map of all the nodes in this graph, indexed by their key.
 	nodes map[string]*Node
+	// Graph level metadata.
+	meta *Meta
 }
 
 // The Node object.
@@ -69,6 +74,7 @@ func (node *Node) Value() interface{} {
 func New() *Graph {
 	return &Graph{
 		nodes: map[string]*Node{},
+		meta:  &Meta{Attrs: map[string]string{}},
 	}
 }
 
@@ -335,23 +341,118 @@ func (node *Node) Normalize(isLog bool) {
 	}
 
 	// IsLog == true
-	// convert to linear.
+	// Subtract the log of the sum computed with log-sum-exp to avoid
+	// underflow when converting to the linear domain.
+	sum = logSumExp(node.successors)
+	if math.IsInf(sum, 0) {
+		return
+	}
 	for snode, w := range node.successors {
-		node.successors[snode] = math.Exp(w)
+		node.successors[snode] = w - sum
 	}
-	// Normalize to probs. in linear domain.
-	node.Normalize(false)
+}
 
-	// Convert to log probs.
-	node.ConvertToLogProbs()
+// Returns log(sum(exp(w))) for the weights in the map. The max weight is
+// factored out so the largest term is exp(0) = 1.
+func logSumExp(weights map[*Node]float64) float64 {
+	max := math.Inf(-1)
+	for _, w := range weights {
+		if w > max {
+			max = w
+		}
+	}
+	if math.IsInf(max, 0) {
+		return max
+	}
+	var sum float64
+	for _, w := range weights {
+		sum += math.Exp(w - max)
+	}
+	return max + math.Log(sum)
 }
 
 // Normalize converts arc weights to probabilities such that the sum of
 // the weights of the outbound arcs for a given node equals one.
-func (g *Graph) Normalize(isLog bool) {
+// Returns an error if the weight kind of the graph doesn't match isLog.
+// On success, the weight kind is set to LogWeight or LinearWeight.
+func (g *Graph) Normalize(isLog bool) error {
+	kind := logKind(isLog)
+	if e := g.checkWeights("Normalize", kind); e != nil {
+		return e
+	}
 	for _, node := range g.nodes {
 		node.Normalize(isLog)
 	}
+	g.Meta().Weights = kind
+	return nil
+}
+
+// ConvertWeights converts outbound arc weights from one weight kind to
+// another. Kinds must be one of LinearWeight, LogWeight, CostWeight or
+// Log10Weight.
+func (node *Node) ConvertWeights(from, to WeightKind) error {
+	if e := checkConvertible(from, to); e != nil {
+		return e
+	}
+	for snode, w := range node.successors {
+		node.successors[snode] = fromLog(toLog(w, from), to)
+	}
+	return nil
+}
+
+// ConvertWeights converts arc weights from one weight kind to another.
+// Kinds must be one of LinearWeight, LogWeight, CostWeight or Log10Weight.
+// Returns an error if the weight kind of the graph is known and is not
+// "from". On success, the weight kind is set to "to".
+func (g *Graph) ConvertWeights(from, to WeightKind) error {
+	if e := checkConvertible(from, to); e != nil {
+		return e
+	}
+	if e := g.checkWeights("ConvertWeights", from); e != nil {
+		return e
+	}
+	for _, node := range g.nodes {
+		node.ConvertWeights(from, to)
+	}
+	g.Meta().Weights = to
+	return nil
+}
+
+func checkConvertible(kinds ...WeightKind) error {
+	for _, k := range kinds {
+		switch k {
+		case LinearWeight, LogWeight, CostWeight, Log10Weight:
+		default:
+			return fmt.Errorf("graph: cannot convert %s weights", k)
+		}
+	}
+	return nil
+}
+
+// Converts a weight to natural log.
+func toLog(w float64, kind WeightKind) float64 {
+	switch kind {
+	case LinearWeight:
+		return math.Log(w)
+	case CostWeight:
+		return -w
+	case Log10Weight:
+		return w * math.Ln10
+	}
+	return w
+}
+
+// Converts a natural log weight to another kind.
+func fromLog(w float64, kind WeightKind) float64 {
+	switch kind {
+	case LinearWeight:
+		return math.Exp(w)
+	case CostWeight:
+		return -w
+	case Log10Weight:
+		return w / math.Ln10
+	}
+	return w
 }
 
 // ConvertToLogProbs converts arc weights to log probabilities.
@@ -362,38 +463,33 @@ func (node *Node) ConvertToLogProbs() {
 }
 
 // ConvertToLogProbs converts arc weights to log probabilities.
-func (g *Graph) ConvertToLogProbs() {
+// Returns an error if the graph weights are known not to be linear.
+// On success, the weight kind is set to LogWeight.
+func (g *Graph) ConvertToLogProbs() error {
+	if e := g.checkWeights("ConvertToLogProbs", LinearWeight); e != nil {
+		return e
+	}
 	for _, node := range g.nodes {
 		node.ConvertToLogProbs()
 	}
+	g.Meta().Weights = LogWeight
+	return nil
 }
 
 // TransitionMatrix returns a slice of keys sorted alphabetically and the corresponding
 // transition matrix of type [][]float64. Rows with no outbound arcs
 // have a nil slice. If isLog is true, missing connections are set to -Inf,
-// zero otherwise.
-func (g *Graph) TransitionMatrix(isLog bool) (keys []string, weights [][]float64) {
+// zero otherwise. Returns an error if the weight kind of the graph doesn't
+// match isLog.
+func (g *Graph) TransitionMatrix(isLog bool) (keys []string, weights [][]float64, err error) {
 
+	if err = g.checkWeights("TransitionMatrix", logKind(isLog)); err != nil {
+		return
+	}
 	n := g.Len()
 	weights = make([][]float64, n)
-
-	// Put nodes in a slice.
-	nodes := make([]*Node, n)
+	nodes, index := g.sortedNodes()
 	keys = make([]string, n)
-	index := make(map[*Node]int)
-	var k int
-	for _, x := range g.nodes {
-		nodes[k] = x
-		k += 1
-	}
-
-	// Sort nodes by name.
-	sort.Sort(ByName{nodes})
-
-	// Map Node name to matrix index.
-	for k, v := range nodes {
-		index[v] = k
-	}
 
 	// Put transition weights in matrix.
 	for _, fromNode := range nodes {
@@ -415,9 +511,72 @@ func (g *Graph) TransitionMatrix(isLog bool) (keys []string, weights [][]float64
 	return
 }
 
+// SparseTransitionMatrix returns a slice of keys sorted alphabetically and
+// the corresponding transition matrix in compressed sparse row format.
+// Indices follow the same key order as TransitionMatrix. Within a row,
+// column indices are sorted. Arcs with a weight of zero (or -Inf if isLog
+// is true) are not stored. Returns an error if the weight kind of the graph
+// doesn't match isLog.
+func (g *Graph) SparseTransitionMatrix(isLog bool) (keys []string, m *CSR, err error) {
+
+	if err = g.checkWeights("SparseTransitionMatrix", logKind(isLog)); err != nil {
+		return
+	}
+	nodes, index := g.sortedNodes()
+	n := len(nodes)
+	keys = make([]string, n)
+	var nnz int
+	for _, node := range nodes {
+		nnz += len(node.successors)
+	}
+	m = &CSR{
+		RowPtr: make([]int, n+1),
+		ColIdx: make([]int, 0, nnz),
+		Values: make([]float64, 0, nnz),
+	}
+
+	for i, fromNode := range nodes {
+		keys[i] = fromNode.key
+		succ, cols := sortedSuccessors(fromNode, index)
+		for k, toNode := range succ {
+			w := fromNode.successors[toNode]
+			if isMissing(w, isLog) {
+				continue
+			}
+			m.ColIdx = append(m.ColIdx, cols[k])
+			m.Values = append(m.Values, w)
+		}
+		m.RowPtr[i+1] = len(m.Values)
+	}
+	return
+}
+
+// Returns the nodes sorted by key and a map from node to its
+// position in the sorted slice.
+func (g *Graph) sortedNodes() (nodes []*Node, index map[*Node]int) {
+
+	// Put nodes in a slice.
+	nodes = make([]*Node, 0, g.Len())
+	for _, x := range g.nodes {
+		nodes = append(nodes, x)
+	}
+
+	// Sort nodes by name.
+	sort.Sort(ByName{nodes})
+
+	// Map Node name to matrix index.
+	index = make(map[*Node]int, len(nodes))
+	for k, v := range nodes {
+		index[v] = k
+	}
+	return
+}
+
 // Merge combines graphs as follows:
 // Nodes and arcs are [deep] copied to the new structure without modifications.
 // Returns ErrDuplicateKey if any of the keys is duplicated.
+// Returns a WeightKindError if the graphs have weights of different known
+// kinds, otherwise the receiver takes the known kind.
 func (g *Graph) Merge(graphs ...*Graph) error {
 
 	// Verify that there are no duplicates before starting to merge.
@@ -435,6 +594,11 @@ func (g *Graph) Merge(graphs ...*Graph) error {
 			tmpMap[k] = true
 		}
 	}
+	kind, e := g.mergeKind("Merge", graphs)
+	if e != nil {
+		return e
+	}
+	g.Meta().Weights = kind
 
 	// We are good, start merging.
 	for _, gg := range graphs {
@@ -456,6 +620,7 @@ func (g *Graph) Merge(graphs ...*Graph) error {
 // Add adds graphs as follows:
 // Nodes and arcs are moved (not copied) to the main graph.
 // Returns ErrDuplicateKey if any of the keys is duplicated.
+// Weight kinds are checked as in Merge.
 // Both the main and added graphs will point to the same node and arc objects.
 func (g *Graph) Add(graphs ...*Graph) error {
 
@@ -474,6 +639,11 @@ func (g *Graph) Add(graphs ...*Graph) error {
 			tmpMap[k] = true
 		}
 	}
+	kind, e := g.mergeKind("Add", graphs)
+	if e != nil {
+		return e
+	}
+	g.Meta().Weights = kind
 
 	// We are good, start merging.
 	for _, gg := range graphs {
@@ -491,12 +661,11 @@ func (g *Graph) Add(graphs ...*Graph) error {
 // String returns the graph as a string in YAML format.
 func (g *Graph) String() (st string) {
 
-	buf := new(bytes.Buffer)
-	err := g.WriteYAML(buf)
+	b, err := goyaml.Marshal(g.exportGraph())
 	if err != nil {
 		panic(err)
 	}
-	return buf.String()
+	return string(b)
 }
 
 // Sort Nodes.
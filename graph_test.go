@@ -152,7 +152,10 @@ func TestDelete(t *testing.T) {
 func TestTransitionMatrix(t *testing.T) {
 
 	g := sampleGraph(t)
-	keys, weights := g.TransitionMatrix(false)
+	keys, weights, err := g.TransitionMatrix(false)
+	if err != nil {
+		t.Fatal(err)
+	}
 	lastKey := ""
 
 	for i, from := range keys {
@@ -184,7 +187,10 @@ func TestTransitionMatrix(t *testing.T) {
 func TestLogTransitionMatrix(t *testing.T) {
 
 	g := sampleGraph(t)
-	keys, weights := g.TransitionMatrix(true)
+	keys, weights, err := g.TransitionMatrix(true)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	for i, from := range keys {
 
@@ -207,6 +213,49 @@ func TestLogTransitionMatrix(t *testing.T) {
 	}
 }
 
+func TestSparseTransitionMatrix(t *testing.T) {
+
+	g := sampleGraph(t)
+	keys, weights, err := g.TransitionMatrix(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	skeys, m, err := g.SparseTransitionMatrix(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != len(skeys) {
+		t.Fatalf("length mismatch [%d] vs. [%d]", len(keys), len(skeys))
+	}
+	if len(m.Values) != 4 {
+		t.Fatalf("expected 4 stored entries, got [%d]", len(m.Values))
+	}
+	for i := range keys {
+		if keys[i] != skeys[i] {
+			t.Fatalf("key mismatch at [%d]: [%s] vs. [%s]", i, keys[i], skeys[i])
+		}
+		row := make([]float64, len(keys))
+		for k := m.RowPtr[i]; k < m.RowPtr[i+1]; k++ {
+			if k > m.RowPtr[i] && m.ColIdx[k] <= m.ColIdx[k-1] {
+				t.Fatalf("column indices not sorted in row [%d]", i)
+			}
+			row[m.ColIdx[k]] = m.Values[k]
+		}
+		if len(weights[i]) == 0 {
+			if m.RowPtr[i] != m.RowPtr[i+1] {
+				t.Fatalf("expected empty row [%d]", i)
+			}
+			continue
+		}
+		for j := range keys {
+			if row[j] != weights[i][j] {
+				t.Fatalf("weights don't match at (%d,%d): [%f] vs. [%f]", i, j, row[j], weights[i][j])
+			}
+		}
+	}
+}
+
 func TestLogProbs(t *testing.T) {
 
 	g0 := sampleGraph(t)
@@ -214,8 +263,14 @@ func TestLogProbs(t *testing.T) {
 	g1.ConvertToLogProbs()
 	g1.Normalize(true)
 
-	keys, weights0 := g0.TransitionMatrix(false)
-	_, weights1 := g1.TransitionMatrix(true)
+	keys, weights0, err := g0.TransitionMatrix(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, weights1, err := g1.TransitionMatrix(true)
+	if err != nil {
+		t.Fatal(err)
+	}
 	n := len(weights0)
 
 	if n != len(weights1) {
@@ -245,7 +300,7 @@ func TestLogProbs(t *testing.T) {
 			if ok0 {
 				w0n := math.Log(weights0[i][j] / sum)
 				t.Logf("weights [%f] vs. [%f]", w0n, weights1[i][j])
-				if w0n != weights1[i][j] {
+				if !Comparef64(w0n, weights1[i][j], 1e-12) {
 					t.Fatalf("weights don't match [%f] vs. [%f]", w0n, weights1[i][j])
 				}
 			} else {
@@ -261,6 +316,65 @@ func TestLogProbs(t *testing.T) {
 	}
 }
 
+func TestNormalizeLogUnderflow(t *testing.T) {
+
+	// Probabilities too small to be represented in the linear domain.
+	g := New()
+	g.Set("a", nil)
+	g.Set("b", nil)
+	g.Set("c", nil)
+	g.Connect("a", "b", -1000)
+	g.Connect("a", "c", -1000-math.Log(3))
+	g.Meta().Weights = LogWeight
+	if e := g.Normalize(true); e != nil {
+		t.Fatal(e)
+	}
+	_, wb := g.IsConnected("a", "b")
+	_, wc := g.IsConnected("a", "c")
+	if !Comparef64(wb, math.Log(0.75), 1e-12) || !Comparef64(wc, math.Log(0.25), 1e-12) {
+		t.Fatalf("expected [%f] and [%f], got [%f] and [%f]", math.Log(0.75), math.Log(0.25), wb, wc)
+	}
+}
+
+func TestConvertWeights(t *testing.T) {
+
+	g0 := sampleGraph(t)
+	g0.Normalize(false)
+	g1, _ := g0.Clone()
+
+	for _, kinds := range [][2]WeightKind{
+		{LinearWeight, LogWeight},
+		{LogWeight, Log10Weight},
+		{Log10Weight, CostWeight},
+		{CostWeight, LinearWeight},
+	} {
+		if e := g1.ConvertWeights(kinds[0], kinds[1]); e != nil {
+			t.Fatal(e)
+		}
+		if g1.Meta().Weights != kinds[1] {
+			t.Fatalf("expected [%s] weights, got [%s]", kinds[1], g1.Meta().Weights)
+		}
+	}
+	if e := compareGraphs(g0, g1); e != nil {
+		t.Fatal(e)
+	}
+
+	_, w := g1.IsConnected("1", "2")
+	g1.ConvertWeights(LinearWeight, CostWeight)
+	_, c := g1.IsConnected("1", "2")
+	if !Comparef64(c, -math.Log(w), 1e-12) {
+		t.Fatalf("expected cost [%f], got [%f]", -math.Log(w), c)
+	}
+
+	// Wrong source kind.
+	if e := g1.ConvertWeights(LogWeight, LinearWeight); e == nil {
+		t.Fatalf("expected error converting cost weights as log weights")
+	}
+	if e := g1.ConvertWeights(CostWeight, UnknownWeight); e == nil {
+		t.Fatalf("expected error converting to unknown weights")
+	}
+}
+
 func TestClone(t *testing.T) {
 	g := sampleGraph(t)
 
@@ -318,6 +432,29 @@ func TestMerge(t *testing.T) {
 	if e := g.Merge(g2); e != ErrDuplicateKey {
 		t.Fatalf("expected DuplicateKeyError, got [%v]", e)
 	}
+
+	// Weight kinds must match.
+	g3 := New()
+	g3.Set("9", nil)
+	g3.Meta().Weights = LogWeight
+	if e := g.Merge(g3); e != nil {
+		t.Fatal(e)
+	}
+	if k := g.Meta().Weights; k != LogWeight {
+		t.Fatalf("expected log weights, got [%s]", k)
+	}
+	g4 := New()
+	g4.Set("10", nil)
+	g4.Meta().Weights = CostWeight
+	if _, ok := g.Merge(g4).(*WeightKindError); !ok {
+		t.Fatal("expected WeightKindError from Merge")
+	}
+	if _, ok := g.Add(g4).(*WeightKindError); !ok {
+		t.Fatal("expected WeightKindError from Add")
+	}
+	if _, e := g.Get("10"); e == nil {
+		t.Fatal("node added despite weight kind error")
+	}
 }
 
 func TestGob(t *testing.T) {
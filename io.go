@@ -11,21 +11,112 @@ import (
 	"encoding/gob"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"time"
 
 	"launchpad.net/goyaml"
 )
 
+// IOVersion is the GraphIO format version written by this package.
+// Files without a version field are version 0.
+const IOVersion = 1
+
+// IOCreator is written in the metadata header of exported graphs.
+var IOCreator = "github.com/akualab/graph"
+
 // Struct to export/import a graph.
 type GraphIO struct {
 	inv map[*Node]string
+	// Format version.
+	Version int `json:"version"`
+	// Metadata header.
+	Meta *IOMeta `json:"meta,omitempty"`
 	// Node values indexed by key.
 	Nodes map[string]interface{} `json:"nodes"`
 	// Arc weight indexed by start node and end node keys.
 	Arcs map[string]map[string]float64 `json:"arcs"`
 }
 
+// IOMeta is the metadata header of an exported graph.
+type IOMeta struct {
+	// Program or package that created the file.
+	Creator string `json:"creator,omitempty"`
+	// Creation time in RFC 3339 format.
+	Created string `json:"created,omitempty"`
+	// True if arcs are directed.
+	Directed bool `json:"directed"`
+	// Semantics of the arc weights, for example "linear" or "log".
+	Weights WeightKind `json:"weights,omitempty"`
+	// Graph name.
+	Name string `json:"name,omitempty"`
+	// Free-form graph attributes.
+	Attrs map[string]string `json:"attrs,omitempty"`
+}
+
+// VersionError is returned when reading a file whose format version is
+// newer than IOVersion.
+type VersionError struct {
+	Version int
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("graph: file format version %d is newer than supported version %d, upgrade the graph package",
+		e.Version, IOVersion)
+}
+
+// A Migration upgrades a GraphIO in place from one format version to
+// the next.
+type Migration func(gio *GraphIO) error
+
+// Migrations indexed by the version they upgrade from.
+var migrations = map[int]Migration{
+	0: migrateV0,
+}
+
+// RegisterMigration sets the migration that upgrades a GraphIO from
+// version "from" to version from+1, replacing any existing migration for
+// that version. Use it to upgrade application specific node values
+// stored in old files; call the migration returned by this function to
+// keep the default behavior.
+func RegisterMigration(from int, m Migration) (old Migration) {
+	old = migrations[from]
+	migrations[from] = m
+	return
+}
+
+// Version 0 files have no metadata header.
+func migrateV0(gio *GraphIO) error {
+	if gio.Meta == nil {
+		gio.Meta = &IOMeta{Directed: true}
+	}
+	return nil
+}
+
+// Upgrades gio to IOVersion. Returns an error if the version is newer
+// than IOVersion.
+func (gio *GraphIO) migrate() error {
+
+	if gio.Version > IOVersion {
+		return &VersionError{Version: gio.Version}
+	}
+	if gio.Version < 0 {
+		return fmt.Errorf("graph: invalid file format version %d", gio.Version)
+	}
+	for gio.Version < IOVersion {
+		m := migrations[gio.Version]
+		if m == nil {
+			return fmt.Errorf("graph: no migration from file format version %d", gio.Version)
+		}
+		if e := m(gio); e != nil {
+			return fmt.Errorf("graph: migration from file format version %d failed: %s", gio.Version, e)
+		}
+		gio.Version++
+	}
+	return nil
+}
+
 // adds a key - node pair to the GraphIO
 func (g GraphIO) add(v *Node) {
 	// set the key - node pair
@@ -50,7 +141,13 @@ func (g *Graph) exportGraph() (gio *GraphIO) {
 		}
 	}
 
-	gio = &GraphIO{inv, map[string]interface{}{}, map[string]map[string]float64{}}
+	gio = &GraphIO{
+		inv:     inv,
+		Version: IOVersion,
+		Meta:    g.ioMeta(),
+		Nodes:   map[string]interface{}{},
+		Arcs:    map[string]map[string]float64{},
+	}
 
 	// add nodes and arcs to gio
 	for _, v := range g.nodes {
@@ -60,23 +157,32 @@ func (g *Graph) exportGraph() (gio *GraphIO) {
 	return
 }
 
+// Sets the creation time. Only used when writing files so that
+// in-memory exports such as String and Clone are deterministic.
+func (gio *GraphIO) stamp() {
+	gio.Meta.Created = time.Now().UTC().Format(time.RFC3339)
+}
+
+// Returns the metadata header for export.
+func (g *Graph) ioMeta() *IOMeta {
+	meta := g.Meta()
+	attrs := make(map[string]string, len(meta.Attrs))
+	for k, v := range meta.Attrs {
+		attrs[k] = v
+	}
+	return &IOMeta{
+		Creator:  IOCreator,
+		Directed: true,
+		Weights:  meta.Weights,
+		Name:     meta.Name,
+		Attrs:    attrs,
+	}
+}
+
 // Encodes the graph into a []byte. With this method, graph implements the
 // gob.GobEncoder interface.
 func (g *Graph) GobEncode() ([]byte, error) {
-	// build inverted map
-	inv := map[*Node]string{}
-	for key, v := range g.nodes {
-		if _, ok := inv[v]; !ok {
-			inv[v] = key
-		}
-	}
-
-	gGob := GraphIO{inv, map[string]interface{}{}, map[string]map[string]float64{}}
-
-	// add nodes and arcs to gGob
-	for _, v := range g.nodes {
-		gGob.add(v)
-	}
+	gGob := g.exportGraph()
 
 	// encode gGob
 	buf := &bytes.Buffer{}
@@ -99,27 +205,14 @@ func (g *Graph) GobDecode(b []byte) (err error) {
 		return
 	}
 
-	// set the nodes
-	for key, value := range gGob.Nodes {
-		g.Set(key, value)
-	}
-
-	// connect the nodes
-	for key, successors := range gGob.Arcs {
-		for otherKey, weight := range successors {
-			if ok := g.Connect(key, otherKey, weight); !ok {
-				return errors.New("invalid arc endpoints")
-			}
-		}
-	}
-
-	return
+	return gGob.initGraph(g)
 }
 
 // Writes Graph to an io.Writer in YAML.
 func (g *Graph) WriteYAML(w io.Writer) error {
 
 	gio := g.exportGraph()
+	gio.stamp()
 	b, err := goyaml.Marshal(gio)
 	if err != nil {
 		return err
@@ -128,6 +221,28 @@ func (g *Graph) WriteYAML(w io.Writer) error {
 	return e
 }
 
+// Reads a graph in YAML format from an io.Reader.
+// Unlike goyaml.Unmarshal, errors found while building the graph,
+// such as an unsupported format version, are returned to the caller.
+func ReadYAML(r io.Reader) (*Graph, error) {
+
+	b, e := ioutil.ReadAll(r)
+	if e != nil {
+		return nil, e
+	}
+	gio := &GraphIO{}
+	e = goyaml.Unmarshal(b, gio)
+	if e != nil {
+		return nil, e
+	}
+	g := New()
+	e = gio.initGraph(g)
+	if e != nil {
+		return nil, e
+	}
+	return g, nil
+}
+
 // Implements json.Marshaler interface.
 func (g *Graph) MarshalJSON() (b []byte, e error) {
 
@@ -189,6 +304,22 @@ func (g *Graph) SetYAML(tag string, value interface{}) bool {
 
 func (gio *GraphIO) initGraph(g *Graph) (e error) {
 
+	// upgrade old formats
+	e = gio.migrate()
+	if e != nil {
+		return
+	}
+
+	// set the metadata
+	if gio.Meta != nil {
+		meta := g.Meta()
+		meta.Name = gio.Meta.Name
+		meta.Weights = gio.Meta.Weights
+		for k, v := range gio.Meta.Attrs {
+			meta.Attrs[k] = v
+		}
+	}
+
 	// set the nodes
 	for key, value := range gio.Nodes {
 		g.Set(key, value)
@@ -226,7 +357,9 @@ func ReadJSONGraph(fn string) (*Graph, error) {
 // Write graph in JSON format.
 func (g *Graph) WriteJSONGraph(fn string) error {
 
-	b, e := g.MarshalJSON()
+	gio := g.exportGraph()
+	gio.stamp()
+	b, e := json.Marshal(gio)
 	if e != nil {
 		return e
 	}
@@ -0,0 +1,184 @@
+// Copyright (c) 2013 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graph
+
+import (
+	"fmt"
+	"math"
+)
+
+// LatticeEndKey is the key of the end node of lattices generated by
+// the Decoder.
+const LatticeEndKey = "</lattice>"
+
+// Transition between two tokens in consecutive frames.
+type latKey struct {
+	from, to *Node
+}
+
+type latArc struct {
+	// Transition score (sum of arc weights, including null nodes) and
+	// acoustic score (score of the observation).
+	trans, ac float64
+}
+
+// Records the transitions between surviving tokens while decoding.
+type latticeRec struct {
+	// Indexed by frame of the destination token.
+	arcs []map[latKey]latArc
+	// Best score of the surviving tokens in each frame.
+	alpha []map[*Node]float64
+}
+
+// Records the transition that created an emitting token. When several
+// null paths connect the same tokens, the best one is kept.
+func (l *latticeRec) addArc(t *Token, ac float64) {
+
+	// Go back through the null tokens created in this frame.
+	src := t.BT
+	for src.BT != nil && src.Index == t.Index {
+		src = src.BT
+	}
+	for len(l.arcs) <= t.Index {
+		l.arcs = append(l.arcs, make(map[latKey]latArc))
+	}
+	k := latKey{src.Node, t.Node}
+	a := latArc{trans: t.Score - ac - src.Score, ac: ac}
+	if old, ok := l.arcs[t.Index][k]; !ok || a.trans+a.ac > old.trans+old.ac {
+		l.arcs[t.Index][k] = a
+	}
+}
+
+// Records the surviving tokens of a frame.
+func (l *latticeRec) addFrame(active []*Token) {
+
+	alpha := make(map[*Node]float64, len(active))
+	for _, t := range active {
+		if s, ok := alpha[t.Node]; !ok || t.Score > s {
+			alpha[t.Node] = t.Score
+		}
+	}
+	l.alpha = append(l.alpha, alpha)
+	for len(l.arcs) < len(l.alpha) {
+		l.arcs = append(l.arcs, make(map[latKey]latArc))
+	}
+}
+
+// Returns the key of a lattice node.
+func latticeKey(node *Node, frame int) string {
+	return fmt.Sprintf("%s:%d", node.key, frame)
+}
+
+// DecodeLattice decodes the observations and returns a lattice with
+// every transition between surviving tokens whose best path score is
+// within beam of the best path score. If beam is zero, no transitions
+// are removed. Lattice nodes correspond to (state, frame) pairs and are
+// keyed "state:frame"; the start node has frame -1 and the lattice ends
+// in a node with key LatticeEndKey. Node values are of type SLFNode with
+// the state key as the word and the frame number plus one as the time.
+// Link attributes hold the acoustic score (Acoustic) and the sum of the
+// arc weights (LM) of each transition; arc weights are their sum and the
+// weight kind of the lattice is LogWeight. When several paths through
+// null nodes connect the same pair of lattice nodes, only the best one is
+// kept. WriteSLF with the default header (see NewSLFHeader) preserves the
+// arc weights.
+// In RequireFinal mode, only tokens that can reach the end node are
+// connected to the lattice end node and the arcs into it have the final
+// weights; returns ErrNoCompletePath if there are none.
+// Returns ErrNoPath if no hypothesis survives.
+func (d *Decoder) DecodeLattice(obs []interface{}, beam float64) (*Graph, error) {
+
+	d.lattice = &latticeRec{}
+	defer func() { d.lattice = nil }()
+	d.Decode(obs)
+	l := d.lattice
+
+	T := len(obs)
+	if T == 0 || len(l.alpha[T-1]) == 0 {
+		return nil, ErrNoPath
+	}
+
+	// Best score to the end of the lattice from each surviving token.
+	beta := make([]map[*Node]float64, T)
+	for t := range beta {
+		beta[t] = make(map[*Node]float64)
+	}
+	best := math.Inf(-1)
+	for n, s := range l.alpha[T-1] {
+		f, ok := d.finalWeight(n)
+		if !ok {
+			continue
+		}
+		beta[T-1][n] = f
+		best = math.Max(best, s+f)
+	}
+	if math.IsInf(best, -1) {
+		return nil, ErrNoCompletePath
+	}
+	for t := T - 1; t > 0; t-- {
+		for k, a := range l.arcs[t] {
+			b, ok := beta[t][k.to]
+			if !ok {
+				continue
+			}
+			if v, ok := beta[t-1][k.from]; !ok || a.trans+a.ac+b > v {
+				beta[t-1][k.from] = a.trans + a.ac + b
+			}
+		}
+	}
+
+	lat := New()
+	values := make(map[string]*SLFNode)
+	addNode := func(node *Node, frame int) string {
+		key := latticeKey(node, frame)
+		if _, ok := values[key]; !ok {
+			lat.Set(key, nil)
+			values[key] = &SLFNode{Time: float64(frame + 1), Word: node.key, Links: map[string]SLFLink{}}
+		}
+		return key
+	}
+	connect := func(from, to string, a latArc) {
+		lat.Connect(from, to, a.trans+a.ac)
+		values[from].Links[to] = SLFLink{Word: values[to].Word, Acoustic: a.ac, LM: a.trans}
+	}
+
+	for t := 0; t < T; t++ {
+		for k, a := range l.arcs[t] {
+			alpha := 0.0
+			if t > 0 {
+				var ok bool
+				if alpha, ok = l.alpha[t-1][k.from]; !ok {
+					continue
+				}
+			}
+			b, ok := beta[t][k.to]
+			if !ok {
+				continue
+			}
+			if _, ok := l.alpha[t][k.to]; !ok {
+				continue
+			}
+			if beam > 0 && alpha+a.trans+a.ac+b < best-beam {
+				continue
+			}
+			connect(addNode(k.from, t-1), addNode(k.to, t), a)
+		}
+	}
+
+	lat.Set(LatticeEndKey, nil)
+	values[LatticeEndKey] = &SLFNode{Time: float64(T), Links: map[string]SLFLink{}}
+	for n := range l.alpha[T-1] {
+		key := latticeKey(n, T-1)
+		if _, ok := values[key]; ok {
+			connect(key, LatticeEndKey, latArc{trans: beta[T-1][n]})
+		}
+	}
+	for key, v := range values {
+		lat.Set(key, *v)
+	}
+	lat.Meta().Weights = LogWeight
+	return lat, nil
+}
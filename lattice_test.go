@@ -0,0 +1,116 @@
+// Copyright (c) 2013 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graph
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDecodeLattice(t *testing.T) {
+
+	obs := []interface{}{1, 2, 2, 3, 3}
+	g := chainGraph()
+	dec, e := NewDecoder(g)
+	if e != nil {
+		t.Fatal(e)
+	}
+	best := dec.Decode(obs)
+	lat, e := dec.DecodeLattice(obs, 0)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	// Best path in the lattice is the Viterbi path.
+	v, e := lat.TotalWeight(MaxPlusSemiring)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !Comparef64(v, best.Score, 1e-9) {
+		t.Fatalf("expected best score [%f], got [%f]", best.Score, v)
+	}
+
+	// Without pruning, the lattice is the full trellis.
+	fb, e := NewForwardBackward(g)
+	if e != nil {
+		t.Fatal(e)
+	}
+	_, ll := fb.Forward(obs)
+	total, e := lat.TotalWeight(LogSemiring)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !Comparef64(total, ll, 1e-9) {
+		t.Fatalf("expected total [%f], got [%f]", ll, total)
+	}
+
+	// Link attributes.
+	s1, e := lat.Get("s1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	val := s1.Value().(SLFNode)
+	if val.Word != "s1" || val.Time != 1 {
+		t.Fatalf("wrong node value %+v", val)
+	}
+	link := val.Links["s2:1"]
+	if !Comparef64(link.Acoustic, peakScore(2)(2), 1e-12) || !Comparef64(link.LM, -0.6931471805599453, 1e-12) {
+		t.Fatalf("wrong link %+v", link)
+	}
+
+	// A narrow beam keeps the best path only.
+	pruned, e := dec.DecodeLattice(obs, 1e-9)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if pruned.Len() != len(obs)+2 {
+		t.Fatalf("expected [%d] nodes, got [%d]", len(obs)+2, pruned.Len())
+	}
+	v, e = pruned.TotalWeight(MaxPlusSemiring)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !Comparef64(v, best.Score, 1e-9) {
+		t.Fatalf("expected best score [%f], got [%f]", best.Score, v)
+	}
+}
+
+func TestDecodeLatticeNull(t *testing.T) {
+
+	obs := []interface{}{1, 2, 2, 1}
+	dec, e := NewDecoder(nullGraph())
+	if e != nil {
+		t.Fatal(e)
+	}
+	best := dec.Decode(obs)
+	lat, e := dec.DecodeLattice(obs, 5)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	// Write and read back in SLF format.
+	buf := new(bytes.Buffer)
+	if e = lat.WriteSLF(buf, nil); e != nil {
+		t.Fatal(e)
+	}
+	lat2, _, e := ReadSLF(buf)
+	if e != nil {
+		t.Fatal(e)
+	}
+	for _, l := range []*Graph{lat, lat2} {
+		v, e := l.TotalWeight(MaxPlusSemiring)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if !Comparef64(v, best.Score, 1e-9) {
+			t.Fatalf("expected best score [%f], got [%f]", best.Score, v)
+		}
+	}
+
+	if _, e = dec.DecodeLattice(nil, 0); e != ErrNoPath {
+		t.Fatalf("expected ErrNoPath, got [%v]", e)
+	}
+}
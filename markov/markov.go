@@ -0,0 +1,539 @@
+// Copyright (c) 2013 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package markov analyzes a normalized graph as a discrete time Markov
+// chain. Arc weights are transition probabilities (or log probabilities if
+// the weight kind of the graph is graph.LogWeight). States are indexed
+// following the sorted key order of graph.TransitionMatrix. Nodes with no
+// outbound arcs are treated as absorbing states.
+package markov
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/akualab/graph"
+)
+
+// Tolerance used to check that transition probabilities sum to one.
+const sumTolerance = 1e-9
+
+var (
+	// ErrNotConverged is returned when an iterative method doesn't converge.
+	ErrNotConverged = errors.New("markov: did not converge")
+	// ErrSingular is returned when a linear system has no unique solution.
+	ErrSingular = errors.New("markov: singular system")
+)
+
+// Chain is a discrete time Markov chain.
+type Chain struct {
+	// State keys.
+	Keys []string
+	// Transition matrix in linear domain.
+	P     *graph.CSR
+	index map[string]int
+}
+
+// New creates a chain from a graph. Returns an error if the outbound
+// arc weights of a node don't sum to one.
+func New(g *graph.Graph) (*Chain, error) {
+
+	isLog := g.Meta().Weights == graph.LogWeight
+	keys, p, e := g.SparseTransitionMatrix(isLog)
+	if e != nil {
+		return nil, e
+	}
+	n := len(keys)
+
+	// Add a self loop to states without outbound arcs and convert to linear.
+	m := &graph.CSR{RowPtr: make([]int, n+1)}
+	for i := 0; i < n; i++ {
+		start, end := p.RowPtr[i], p.RowPtr[i+1]
+		if start == end {
+			m.ColIdx = append(m.ColIdx, i)
+			m.Values = append(m.Values, 1)
+			m.RowPtr[i+1] = len(m.Values)
+			continue
+		}
+		var sum float64
+		for k := start; k < end; k++ {
+			w := p.Values[k]
+			if isLog {
+				w = math.Exp(w)
+			}
+			if w < 0 || math.IsNaN(w) {
+				return nil, fmt.Errorf("markov: invalid probability [%f] in arc from [%s] to [%s]", w, keys[i], keys[p.ColIdx[k]])
+			}
+			m.ColIdx = append(m.ColIdx, p.ColIdx[k])
+			m.Values = append(m.Values, w)
+			sum += w
+		}
+		if math.Abs(sum-1) > sumTolerance {
+			return nil, fmt.Errorf("markov: probabilities of state [%s] sum to [%f], graph must be normalized", keys[i], sum)
+		}
+		m.RowPtr[i+1] = len(m.Values)
+	}
+
+	c := &Chain{Keys: keys, P: m, index: make(map[string]int, n)}
+	for i, k := range keys {
+		c.index[k] = i
+	}
+	return c, nil
+}
+
+// Len returns the number of states.
+func (c *Chain) Len() int {
+	return len(c.Keys)
+}
+
+// Index returns the index of the state with key.
+func (c *Chain) Index(key string) (int, bool) {
+	i, ok := c.index[key]
+	return i, ok
+}
+
+// Returns the transition probability from i to j.
+func (c *Chain) prob(i, j int) float64 {
+	for k := c.P.RowPtr[i]; k < c.P.RowPtr[i+1]; k++ {
+		if c.P.ColIdx[k] == j {
+			return c.P.Values[k]
+		}
+	}
+	return 0
+}
+
+// Stationary computes the stationary distribution using power iteration
+// starting from the uniform distribution. To guarantee convergence for
+// periodic chains, it iterates the lazy chain (P+I)/2 which has the same
+// stationary distribution. Stops when the L1 change is less than tol or
+// returns ErrNotConverged after maxIter iterations. For reducible chains,
+// the result depends on the starting distribution.
+func (c *Chain) Stationary(tol float64, maxIter int) ([]float64, error) {
+
+	n := c.Len()
+	if n == 0 {
+		return nil, nil
+	}
+	pi := make([]float64, n)
+	for i := range pi {
+		pi[i] = 1 / float64(n)
+	}
+	for it := 0; it < maxIter; it++ {
+		next := c.P.VecMul(pi)
+		var diff float64
+		for i := range next {
+			next[i] = 0.5 * (next[i] + pi[i])
+			diff += math.Abs(next[i] - pi[i])
+		}
+		pi = next
+		if diff < tol {
+			return pi, nil
+		}
+	}
+	return pi, ErrNotConverged
+}
+
+// StationaryDirect computes the stationary distribution by solving the
+// linear system pi P = pi, sum(pi) = 1 with Gaussian elimination. It
+// uses a dense n×n matrix. Returns ErrSingular if the stationary
+// distribution is not unique (the chain has more than one recurrent class).
+func (c *Chain) StationaryDirect() ([]float64, error) {
+
+	n := c.Len()
+	if n == 0 {
+		return nil, nil
+	}
+
+	// Build (P^T - I) and replace the last equation with sum(pi) = 1.
+	a := make([][]float64, n)
+	for i := range a {
+		a[i] = make([]float64, n)
+		a[i][i] = -1
+	}
+	for i := 0; i < n; i++ {
+		for k := c.P.RowPtr[i]; k < c.P.RowPtr[i+1]; k++ {
+			a[c.P.ColIdx[k]][i] += c.P.Values[k]
+		}
+	}
+	b := make([]float64, n)
+	for j := range a[n-1] {
+		a[n-1][j] = 1
+	}
+	b[n-1] = 1
+
+	x, e := solve(a, [][]float64{b})
+	if e != nil {
+		return nil, e
+	}
+	return x[0], nil
+}
+
+// StateClass is the classification of a state.
+type StateClass int
+
+const (
+	// Transient states are visited a finite number of times.
+	Transient StateClass = iota
+	// Recurrent states are visited infinitely often once reached.
+	Recurrent
+)
+
+func (s StateClass) String() string {
+	if s == Recurrent {
+		return "recurrent"
+	}
+	return "transient"
+}
+
+// CommunicatingClasses returns the communicating classes of the chain
+// (strongly connected components of the transition graph) in reverse
+// topological order.
+func (c *Chain) CommunicatingClasses() [][]int {
+
+	// Tarjan's algorithm.
+	n := c.Len()
+	index := make([]int, n)
+	low := make([]int, n)
+	onStack := make([]bool, n)
+	for i := range index {
+		index[i] = -1
+	}
+	var stack []int
+	var classes [][]int
+	var next int
+
+	var visit func(v int)
+	visit = func(v int) {
+		index[v], low[v] = next, next
+		next++
+		stack = append(stack, v)
+		onStack[v] = true
+		for k := c.P.RowPtr[v]; k < c.P.RowPtr[v+1]; k++ {
+			if c.P.Values[k] == 0 {
+				continue
+			}
+			w := c.P.ColIdx[k]
+			if index[w] < 0 {
+				visit(w)
+				if low[w] < low[v] {
+					low[v] = low[w]
+				}
+			} else if onStack[w] && index[w] < low[v] {
+				low[v] = index[w]
+			}
+		}
+		if low[v] == index[v] {
+			var class []int
+			for {
+				w := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[w] = false
+				class = append(class, w)
+				if w == v {
+					break
+				}
+			}
+			classes = append(classes, class)
+		}
+	}
+	for v := 0; v < n; v++ {
+		if index[v] < 0 {
+			visit(v)
+		}
+	}
+	return classes
+}
+
+// Classify returns the class of each state. A state is recurrent if its
+// communicating class is closed (no transitions leave the class).
+func (c *Chain) Classify() []StateClass {
+
+	classes := c.CommunicatingClasses()
+	result := make([]StateClass, c.Len())
+	member := make([]int, c.Len())
+	for ci, class := range classes {
+		for _, v := range class {
+			member[v] = ci
+		}
+	}
+	for ci, class := range classes {
+		closed := true
+		for _, v := range class {
+			for k := c.P.RowPtr[v]; k < c.P.RowPtr[v+1]; k++ {
+				if c.P.Values[k] > 0 && member[c.P.ColIdx[k]] != ci {
+					closed = false
+				}
+			}
+		}
+		if closed {
+			for _, v := range class {
+				result[v] = Recurrent
+			}
+		}
+	}
+	return result
+}
+
+// AbsorbingStates returns the indices of the states that can't be left.
+func (c *Chain) AbsorbingStates() []int {
+
+	var abs []int
+	for i := 0; i < c.Len(); i++ {
+		if c.prob(i, i) == 1 {
+			abs = append(abs, i)
+		}
+	}
+	return abs
+}
+
+// AbsorptionProbabilities returns, for every transient state, the
+// probability of being absorbed in each absorbing state. The result is
+// indexed by state (rows) and by position in the absorbing slice
+// (columns); rows of non-transient states are nil. Returns ErrSingular
+// if some transient state can't reach an absorbing state.
+func (c *Chain) AbsorptionProbabilities() (probs [][]float64, absorbing []int, err error) {
+
+	absorbing = c.AbsorbingStates()
+	isAbs := make(map[int]int, len(absorbing))
+	for k, a := range absorbing {
+		isAbs[a] = k
+	}
+	class := c.Classify()
+	var transient []int
+	tidx := make(map[int]int)
+	for i, cl := range class {
+		if cl == Transient {
+			tidx[i] = len(transient)
+			transient = append(transient, i)
+		}
+	}
+	probs = make([][]float64, c.Len())
+	if len(transient) == 0 || len(absorbing) == 0 {
+		return probs, absorbing, nil
+	}
+
+	// Solve (I - Q) B = R.
+	nt := len(transient)
+	a := make([][]float64, nt)
+	rhs := make([][]float64, len(absorbing))
+	for k := range rhs {
+		rhs[k] = make([]float64, nt)
+	}
+	for r, i := range transient {
+		a[r] = make([]float64, nt)
+		a[r][r] = 1
+		for k := c.P.RowPtr[i]; k < c.P.RowPtr[i+1]; k++ {
+			j := c.P.ColIdx[k]
+			if t, ok := tidx[j]; ok {
+				a[r][t] -= c.P.Values[k]
+			} else if ak, ok := isAbs[j]; ok {
+				rhs[ak][r] += c.P.Values[k]
+			}
+		}
+	}
+	x, err := solve(a, rhs)
+	if err != nil {
+		return nil, nil, err
+	}
+	for r, i := range transient {
+		probs[i] = make([]float64, len(absorbing))
+		for k := range absorbing {
+			probs[i][k] = x[k][r]
+		}
+	}
+	return probs, absorbing, nil
+}
+
+// HittingTimes returns the expected number of steps to reach any of the
+// target states from every state. Target states have zero hitting time.
+// States from which a target is not reached with probability one have
+// an infinite hitting time.
+func (c *Chain) HittingTimes(targets ...string) ([]float64, error) {
+
+	n := c.Len()
+	isTarget := make([]bool, n)
+	for _, key := range targets {
+		i, ok := c.index[key]
+		if !ok {
+			return nil, fmt.Errorf("markov: invalid key [%s]", key)
+		}
+		isTarget[i] = true
+	}
+
+	// States that can reach a target.
+	canReach := c.reachBackward(isTarget, nil)
+
+	// States that can reach a state that doesn't reach the target,
+	// without going through the target, have infinite hitting time.
+	lost := make([]bool, n)
+	for i := range lost {
+		lost[i] = !canReach[i]
+	}
+	inf := c.reachBackward(lost, isTarget)
+
+	h := make([]float64, n)
+	var unknown []int
+	uidx := make(map[int]int)
+	for i := 0; i < n; i++ {
+		switch {
+		case isTarget[i]:
+		case inf[i]:
+			h[i] = math.Inf(1)
+		default:
+			uidx[i] = len(unknown)
+			unknown = append(unknown, i)
+		}
+	}
+	if len(unknown) == 0 {
+		return h, nil
+	}
+
+	// Solve h_i - sum_j P_ij h_j = 1.
+	a := make([][]float64, len(unknown))
+	b := make([]float64, len(unknown))
+	for r, i := range unknown {
+		a[r] = make([]float64, len(unknown))
+		a[r][r] = 1
+		b[r] = 1
+		for k := c.P.RowPtr[i]; k < c.P.RowPtr[i+1]; k++ {
+			if u, ok := uidx[c.P.ColIdx[k]]; ok {
+				a[r][u] -= c.P.Values[k]
+			}
+		}
+	}
+	x, e := solve(a, [][]float64{b})
+	if e != nil {
+		return nil, e
+	}
+	for r, i := range unknown {
+		h[i] = x[0][r]
+	}
+	return h, nil
+}
+
+// Returns the states that can reach a state in "from" following
+// transitions with positive probability. States in "blocked" are not
+// traversed.
+func (c *Chain) reachBackward(from, blocked []bool) []bool {
+
+	n := c.Len()
+	pred := make([][]int, n)
+	for i := 0; i < n; i++ {
+		for k := c.P.RowPtr[i]; k < c.P.RowPtr[i+1]; k++ {
+			if c.P.Values[k] > 0 {
+				j := c.P.ColIdx[k]
+				pred[j] = append(pred[j], i)
+			}
+		}
+	}
+	reach := make([]bool, n)
+	var queue []int
+	for i, ok := range from {
+		if ok {
+			reach[i] = true
+			queue = append(queue, i)
+		}
+	}
+	for len(queue) > 0 {
+		j := queue[0]
+		queue = queue[1:]
+		for _, i := range pred[j] {
+			if reach[i] || (blocked != nil && blocked[i]) {
+				continue
+			}
+			reach[i] = true
+			queue = append(queue, i)
+		}
+	}
+	return reach
+}
+
+// MixingTime estimates the mixing time: the smallest number of steps t
+// such that the total variation distance between the distribution after
+// t steps and the stationary distribution is at most eps, for every
+// starting state. It runs n distributions forward and costs
+// O(n * arcs * t). Returns ErrNotConverged if the chain has not mixed
+// after maxSteps steps.
+func (c *Chain) MixingTime(eps float64, maxSteps int) (int, error) {
+
+	pi, e := c.StationaryDirect()
+	if e != nil {
+		return 0, e
+	}
+	n := c.Len()
+	dists := make([][]float64, n)
+	for i := range dists {
+		dists[i] = make([]float64, n)
+		dists[i][i] = 1
+	}
+	for t := 0; t <= maxSteps; t++ {
+		var worst float64
+		for _, d := range dists {
+			var tv float64
+			for j := range d {
+				tv += math.Abs(d[j] - pi[j])
+			}
+			if tv/2 > worst {
+				worst = tv / 2
+			}
+		}
+		if worst <= eps {
+			return t, nil
+		}
+		for i := range dists {
+			dists[i] = c.P.VecMul(dists[i])
+		}
+	}
+	return maxSteps, ErrNotConverged
+}
+
+// Solves A X = B for each right hand side in b using Gaussian elimination
+// with partial pivoting. A is modified.
+func solve(a [][]float64, b [][]float64) ([][]float64, error) {
+
+	n := len(a)
+	x := make([][]float64, len(b))
+	for k := range b {
+		x[k] = append([]float64(nil), b[k]...)
+	}
+	for col := 0; col < n; col++ {
+		piv := col
+		for r := col + 1; r < n; r++ {
+			if math.Abs(a[r][col]) > math.Abs(a[piv][col]) {
+				piv = r
+			}
+		}
+		if math.Abs(a[piv][col]) < 1e-12 {
+			return nil, ErrSingular
+		}
+		a[col], a[piv] = a[piv], a[col]
+		for k := range x {
+			x[k][col], x[k][piv] = x[k][piv], x[k][col]
+		}
+		for r := col + 1; r < n; r++ {
+			f := a[r][col] / a[col][col]
+			if f == 0 {
+				continue
+			}
+			for j := col; j < n; j++ {
+				a[r][j] -= f * a[col][j]
+			}
+			for k := range x {
+				x[k][r] -= f * x[k][col]
+			}
+		}
+	}
+	for k := range x {
+		for r := n - 1; r >= 0; r-- {
+			s := x[k][r]
+			for j := r + 1; j < n; j++ {
+				s -= a[r][j] * x[k][j]
+			}
+			x[k][r] = s / a[r][r]
+		}
+	}
+	return x, nil
+}
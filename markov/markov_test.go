@@ -0,0 +1,210 @@
+// Copyright (c) 2013 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package markov
+
+import (
+	"math"
+	"testing"
+
+	"github.com/akualab/graph"
+)
+
+func compare(t *testing.T, name string, got, expected float64) {
+	if math.Abs(got-expected) > 1e-6 {
+		t.Fatalf("%s: expected [%f], got [%f]", name, expected, got)
+	}
+}
+
+// Two state ergodic chain.
+func twoStates() *graph.Graph {
+	g := graph.New()
+	g.Set("a", nil)
+	g.Set("b", nil)
+	g.Connect("a", "a", 0.9)
+	g.Connect("a", "b", 0.1)
+	g.Connect("b", "a", 0.5)
+	g.Connect("b", "b", 0.5)
+	return g
+}
+
+// Gambler's ruin with absorbing states s0 and s3.
+func gamblersRuin() *graph.Graph {
+	g := graph.New()
+	for _, k := range []string{"s0", "s1", "s2", "s3"} {
+		g.Set(k, nil)
+	}
+	g.Connect("s1", "s0", 0.5)
+	g.Connect("s1", "s2", 0.5)
+	g.Connect("s2", "s1", 0.5)
+	g.Connect("s2", "s3", 0.5)
+	return g
+}
+
+func TestStationary(t *testing.T) {
+
+	c, e := New(twoStates())
+	if e != nil {
+		t.Fatal(e)
+	}
+	pi, e := c.Stationary(1e-12, 10000)
+	if e != nil {
+		t.Fatal(e)
+	}
+	compare(t, "power a", pi[0], 5.0/6.0)
+	compare(t, "power b", pi[1], 1.0/6.0)
+
+	pi, e = c.StationaryDirect()
+	if e != nil {
+		t.Fatal(e)
+	}
+	compare(t, "direct a", pi[0], 5.0/6.0)
+	compare(t, "direct b", pi[1], 1.0/6.0)
+}
+
+func TestStationaryLog(t *testing.T) {
+
+	g := twoStates()
+	if e := g.ConvertToLogProbs(); e != nil {
+		t.Fatal(e)
+	}
+	c, e := New(g)
+	if e != nil {
+		t.Fatal(e)
+	}
+	pi, e := c.StationaryDirect()
+	if e != nil {
+		t.Fatal(e)
+	}
+	compare(t, "a", pi[0], 5.0/6.0)
+}
+
+func TestStationaryPeriodic(t *testing.T) {
+
+	g := graph.New()
+	g.Set("a", nil)
+	g.Set("b", nil)
+	g.Connect("a", "b", 1)
+	g.Connect("b", "a", 1)
+	c, e := New(g)
+	if e != nil {
+		t.Fatal(e)
+	}
+	pi, e := c.Stationary(1e-12, 1000)
+	if e != nil {
+		t.Fatal(e)
+	}
+	compare(t, "a", pi[0], 0.5)
+	compare(t, "b", pi[1], 0.5)
+}
+
+func TestNotNormalized(t *testing.T) {
+
+	g := twoStates()
+	g.Connect("a", "b", 0.5)
+	if _, e := New(g); e == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestClassify(t *testing.T) {
+
+	c, e := New(gamblersRuin())
+	if e != nil {
+		t.Fatal(e)
+	}
+	expected := []StateClass{Recurrent, Transient, Transient, Recurrent}
+	for i, cl := range c.Classify() {
+		if cl != expected[i] {
+			t.Fatalf("state [%s]: expected [%s], got [%s]", c.Keys[i], expected[i], cl)
+		}
+	}
+	if n := len(c.CommunicatingClasses()); n != 3 {
+		t.Fatalf("expected 3 classes, got [%d]", n)
+	}
+	abs := c.AbsorbingStates()
+	if len(abs) != 2 || abs[0] != 0 || abs[1] != 3 {
+		t.Fatalf("wrong absorbing states %v", abs)
+	}
+	if _, e := c.StationaryDirect(); e != ErrSingular {
+		t.Fatalf("expected ErrSingular, got [%v]", e)
+	}
+}
+
+func TestAbsorption(t *testing.T) {
+
+	c, e := New(gamblersRuin())
+	if e != nil {
+		t.Fatal(e)
+	}
+	probs, abs, e := c.AbsorptionProbabilities()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(abs) != 2 || probs[0] != nil {
+		t.Fatalf("wrong result %v %v", abs, probs)
+	}
+	compare(t, "s1 -> s0", probs[1][0], 2.0/3.0)
+	compare(t, "s1 -> s3", probs[1][1], 1.0/3.0)
+	compare(t, "s2 -> s0", probs[2][0], 1.0/3.0)
+	compare(t, "s2 -> s3", probs[2][1], 2.0/3.0)
+}
+
+func TestHittingTimes(t *testing.T) {
+
+	c, e := New(gamblersRuin())
+	if e != nil {
+		t.Fatal(e)
+	}
+	h, e := c.HittingTimes("s0", "s3")
+	if e != nil {
+		t.Fatal(e)
+	}
+	for i, expected := range []float64{0, 2, 2, 0} {
+		compare(t, c.Keys[i], h[i], expected)
+	}
+
+	h, e = c.HittingTimes("s0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if h[0] != 0 || !math.IsInf(h[1], 1) || !math.IsInf(h[3], 1) {
+		t.Fatalf("expected infinite hitting times, got %v", h)
+	}
+
+	c, e = New(twoStates())
+	if e != nil {
+		t.Fatal(e)
+	}
+	h, e = c.HittingTimes("b")
+	if e != nil {
+		t.Fatal(e)
+	}
+	compare(t, "a -> b", h[0], 10)
+
+	if _, e = c.HittingTimes("x"); e == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestMixingTime(t *testing.T) {
+
+	c, e := New(twoStates())
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	// TV distance decays as 0.4^t, max initial distance is 5/6.
+	tm, e := c.MixingTime(0.01, 100)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if tm != 5 {
+		t.Fatalf("expected mixing time 5, got [%d]", tm)
+	}
+	if _, e = c.MixingTime(1e-6, 2); e != ErrNotConverged {
+		t.Fatalf("expected ErrNotConverged, got [%v]", e)
+	}
+}
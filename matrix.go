@@ -0,0 +1,519 @@
+// Copyright (c) 2013 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graph
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// COO is a sparse matrix in coordinate format. Entry k has
+// row index Rows[k], column index Cols[k] and value Values[k].
+type COO struct {
+	Rows   []int
+	Cols   []int
+	Values []float64
+}
+
+// CSR is a sparse matrix in compressed sparse row format.
+// The entries of row i are stored in ColIdx[RowPtr[i]:RowPtr[i+1]]
+// and Values[RowPtr[i]:RowPtr[i+1]]. RowPtr has one element more
+// than the number of rows.
+type CSR struct {
+	RowPtr []int
+	ColIdx []int
+	Values []float64
+}
+
+// NumRows returns the number of rows in the matrix.
+func (m *CSR) NumRows() int {
+	if len(m.RowPtr) == 0 {
+		return 0
+	}
+	return len(m.RowPtr) - 1
+}
+
+// ToCOO converts a CSR matrix to coordinate format.
+func (m *CSR) ToCOO() *COO {
+
+	nnz := len(m.Values)
+	coo := &COO{
+		Rows:   make([]int, 0, nnz),
+		Cols:   make([]int, 0, nnz),
+		Values: make([]float64, 0, nnz),
+	}
+	for i := 0; i < m.NumRows(); i++ {
+		for k := m.RowPtr[i]; k < m.RowPtr[i+1]; k++ {
+			coo.Rows = append(coo.Rows, i)
+			coo.Cols = append(coo.Cols, m.ColIdx[k])
+			coo.Values = append(coo.Values, m.Values[k])
+		}
+	}
+	return coo
+}
+
+// ToCSR converts a COO matrix with n rows to compressed sparse row format.
+// Within a row, entries are sorted by column index.
+func (m *COO) ToCSR(n int) (*CSR, error) {
+
+	if len(m.Rows) != len(m.Cols) || len(m.Rows) != len(m.Values) {
+		return nil, fmt.Errorf("coo arrays have different lengths: rows=%d, cols=%d, values=%d",
+			len(m.Rows), len(m.Cols), len(m.Values))
+	}
+
+	// Sort entry positions by row then column.
+	perm := make([]int, len(m.Rows))
+	for k := range perm {
+		perm[k] = k
+	}
+	sort.Slice(perm, func(a, b int) bool {
+		i, j := perm[a], perm[b]
+		if m.Rows[i] != m.Rows[j] {
+			return m.Rows[i] < m.Rows[j]
+		}
+		return m.Cols[i] < m.Cols[j]
+	})
+
+	csr := &CSR{
+		RowPtr: make([]int, n+1),
+		ColIdx: make([]int, len(perm)),
+		Values: make([]float64, len(perm)),
+	}
+	for k, p := range perm {
+		r := m.Rows[p]
+		if r < 0 || r >= n {
+			return nil, fmt.Errorf("row index [%d] out of range, num rows is [%d]", r, n)
+		}
+		csr.RowPtr[r+1]++
+		csr.ColIdx[k] = m.Cols[p]
+		csr.Values[k] = m.Values[p]
+	}
+	for i := 0; i < n; i++ {
+		csr.RowPtr[i+1] += csr.RowPtr[i]
+	}
+	return csr, nil
+}
+
+// MulVec returns the product of the square matrix m and the column
+// vector x: y[i] = sum_j m[i][j] * x[j]. When m is a transition matrix,
+// MulVec propagates values backwards in time (e.g. expected rewards).
+// Panics if the length of x doesn't match the matrix size.
+func (m *CSR) MulVec(x []float64) []float64 {
+
+	n := m.checkDim(x)
+	y := make([]float64, n)
+	for i := 0; i < n; i++ {
+		var sum float64
+		for k := m.RowPtr[i]; k < m.RowPtr[i+1]; k++ {
+			sum += m.Values[k] * x[m.ColIdx[k]]
+		}
+		y[i] = sum
+	}
+	return y
+}
+
+// VecMul returns the product of the row vector x and the square matrix m:
+// y[j] = sum_i x[i] * m[i][j]. When m is a transition matrix and x is a
+// state distribution, VecMul returns the distribution after one step.
+// Panics if the length of x doesn't match the matrix size.
+func (m *CSR) VecMul(x []float64) []float64 {
+
+	n := m.checkDim(x)
+	y := make([]float64, n)
+	for i := 0; i < n; i++ {
+		if x[i] == 0 {
+			continue
+		}
+		for k := m.RowPtr[i]; k < m.RowPtr[i+1]; k++ {
+			y[m.ColIdx[k]] += x[i] * m.Values[k]
+		}
+	}
+	return y
+}
+
+// LogMulVec is the log domain version of MulVec. Values in m and x are
+// log probabilities: y[i] = log(sum_j exp(m[i][j] + x[j])).
+func (m *CSR) LogMulVec(x []float64) []float64 {
+
+	n := m.checkDim(x)
+	y := make([]float64, n)
+	for i := 0; i < n; i++ {
+		sum := math.Inf(-1)
+		for k := m.RowPtr[i]; k < m.RowPtr[i+1]; k++ {
+			sum = logAdd(sum, m.Values[k]+x[m.ColIdx[k]])
+		}
+		y[i] = sum
+	}
+	return y
+}
+
+// LogVecMul is the log domain version of VecMul. Values in m and x are
+// log probabilities: y[j] = log(sum_i exp(x[i] + m[i][j])).
+func (m *CSR) LogVecMul(x []float64) []float64 {
+
+	n := m.checkDim(x)
+	y := make([]float64, n)
+	for j := range y {
+		y[j] = math.Inf(-1)
+	}
+	for i := 0; i < n; i++ {
+		if math.IsInf(x[i], -1) {
+			continue
+		}
+		for k := m.RowPtr[i]; k < m.RowPtr[i+1]; k++ {
+			j := m.ColIdx[k]
+			y[j] = logAdd(y[j], x[i]+m.Values[k])
+		}
+	}
+	return y
+}
+
+func (m *CSR) checkDim(x []float64) int {
+	n := m.NumRows()
+	if len(x) != n {
+		panic(fmt.Sprintf("graph: vector length [%d] doesn't match matrix size [%d]", len(x), n))
+	}
+	return n
+}
+
+// Returns log(exp(a) + exp(b)) avoiding underflow.
+func logAdd(a, b float64) float64 {
+	if a < b {
+		a, b = b, a
+	}
+	if math.IsInf(b, -1) {
+		return a
+	}
+	return a + math.Log1p(math.Exp(b-a))
+}
+
+// Returns true if the matrix entry represents a missing arc.
+func isMissing(w float64, isLog bool) bool {
+	if isLog {
+		return math.IsInf(w, -1)
+	}
+	return w == 0
+}
+
+// Creates a graph with one node per key. Node values are nil. The
+// weight kind is set according to isLog.
+func newGraphFromKeys(keys []string, isLog bool) (*Graph, error) {
+
+	g := New()
+	g.Meta().Weights = logKind(isLog)
+	for _, k := range keys {
+		if g.get(k) != nil {
+			return nil, ErrDuplicateKey
+		}
+		g.Set(k, nil)
+	}
+	return g, nil
+}
+
+// FromMatrix creates a graph from a slice of keys and a transition
+// matrix. It is the inverse of TransitionMatrix: weights[i][j] is the
+// weight of the arc from keys[i] to keys[j]. Rows may be nil. Entries
+// equal to zero (or -Inf when isLog is true) are skipped. Node values
+// are set to nil. The weight kind is set to LogWeight if isLog is true
+// and to LinearWeight otherwise, as in the other matrix loaders.
+func FromMatrix(keys []string, weights [][]float64, isLog bool) (*Graph, error) {
+
+	n := len(keys)
+	if len(weights) != n {
+		return nil, fmt.Errorf("matrix has [%d] rows, expected [%d]", len(weights), n)
+	}
+	g, e := newGraphFromKeys(keys, isLog)
+	if e != nil {
+		return nil, e
+	}
+
+	for i, row := range weights {
+		if len(row) == 0 {
+			continue
+		}
+		if len(row) != n {
+			return nil, fmt.Errorf("row [%d] has [%d] columns, expected [%d]", i, len(row), n)
+		}
+		from := g.get(keys[i])
+		for j, w := range row {
+			if isMissing(w, isLog) {
+				continue
+			}
+			from.successors[g.get(keys[j])] = w
+		}
+	}
+	return g, nil
+}
+
+// FromCOO creates a graph from a slice of keys and a sparse matrix in
+// coordinate format. Duplicate entries overwrite earlier ones. Entries
+// equal to zero (or -Inf when isLog is true) are skipped.
+func FromCOO(keys []string, m *COO, isLog bool) (*Graph, error) {
+
+	if len(m.Rows) != len(m.Cols) || len(m.Rows) != len(m.Values) {
+		return nil, fmt.Errorf("coo arrays have different lengths: rows=%d, cols=%d, values=%d",
+			len(m.Rows), len(m.Cols), len(m.Values))
+	}
+	g, e := newGraphFromKeys(keys, isLog)
+	if e != nil {
+		return nil, e
+	}
+
+	n := len(keys)
+	for k, w := range m.Values {
+		i, j := m.Rows[k], m.Cols[k]
+		if i < 0 || i >= n || j < 0 || j >= n {
+			return nil, fmt.Errorf("entry [%d] has index (%d,%d) out of range, num keys is [%d]", k, i, j, n)
+		}
+		if isMissing(w, isLog) {
+			continue
+		}
+		g.get(keys[i]).successors[g.get(keys[j])] = w
+	}
+	return g, nil
+}
+
+// FromCSR creates a graph from a slice of keys and a sparse matrix in
+// compressed sparse row format. Entries equal to zero (or -Inf when
+// isLog is true) are skipped.
+func FromCSR(keys []string, m *CSR, isLog bool) (*Graph, error) {
+
+	n := len(keys)
+	if len(m.RowPtr) != n+1 {
+		return nil, fmt.Errorf("invalid csr matrix: row pointer has [%d] elements, expected [%d]", len(m.RowPtr), n+1)
+	}
+	if len(m.ColIdx) != len(m.Values) || m.RowPtr[0] != 0 || m.RowPtr[n] != len(m.Values) {
+		return nil, fmt.Errorf("invalid csr matrix: row pointer spans [%d,%d], num col indices [%d], num values [%d]",
+			m.RowPtr[0], m.RowPtr[n], len(m.ColIdx), len(m.Values))
+	}
+	for i := 0; i < n; i++ {
+		if m.RowPtr[i] > m.RowPtr[i+1] {
+			return nil, fmt.Errorf("invalid csr matrix: row pointer decreases at row [%d]", i)
+		}
+	}
+	g, e := newGraphFromKeys(keys, isLog)
+	if e != nil {
+		return nil, e
+	}
+
+	for i := 0; i < n; i++ {
+		from := g.get(keys[i])
+		for k := m.RowPtr[i]; k < m.RowPtr[i+1]; k++ {
+			j := m.ColIdx[k]
+			if j < 0 || j >= n {
+				return nil, fmt.Errorf("column index [%d] in row [%d] out of range, num keys is [%d]", j, i, n)
+			}
+			if isMissing(m.Values[k], isLog) {
+				continue
+			}
+			from.successors[g.get(keys[j])] = m.Values[k]
+		}
+	}
+	return g, nil
+}
+
+const (
+	mmBanner    = "%%MatrixMarket"
+	mmKeyPrefix = "% key "
+)
+
+// WriteMatrixMarket writes the arc weights of the graph to an io.Writer
+// in Matrix Market coordinate format (.mtx). Rows and columns are
+// numbered from one following the sorted key order of TransitionMatrix.
+// Node keys are written as comment lines so the graph can be restored
+// with ReadMatrixMarket. Node values are not written.
+func (g *Graph) WriteMatrixMarket(w io.Writer) error {
+
+	nodes, index := g.sortedNodes()
+	var nnz int
+	for _, node := range nodes {
+		nnz += len(node.successors)
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%s matrix coordinate real general\n", mmBanner)
+	for i, node := range nodes {
+		fmt.Fprintf(bw, "%s%d %s\n", mmKeyPrefix, i+1, strconv.Quote(node.key))
+	}
+	fmt.Fprintf(bw, "%d %d %d\n", len(nodes), len(nodes), nnz)
+
+	for i, node := range nodes {
+		succ, _ := sortedSuccessors(node, index)
+		for _, s := range succ {
+			fmt.Fprintf(bw, "%d %d %s\n", i+1, index[s]+1,
+				strconv.FormatFloat(node.successors[s], 'g', -1, 64))
+		}
+	}
+	return bw.Flush()
+}
+
+// Returns the successors of node sorted by index.
+func sortedSuccessors(node *Node, index map[*Node]int) ([]*Node, []int) {
+
+	succ := make([]*Node, 0, len(node.successors))
+	for s := range node.successors {
+		succ = append(succ, s)
+	}
+	sort.Slice(succ, func(a, b int) bool { return index[succ[a]] < index[succ[b]] })
+	idx := make([]int, len(succ))
+	for k, s := range succ {
+		idx[k] = index[s]
+	}
+	return succ, idx
+}
+
+// ReadMatrixMarket reads a square matrix in Matrix Market coordinate
+// format and returns the corresponding graph. Supported fields are real,
+// integer and pattern (all weights set to one, or zero when isLog is
+// true); supported symmetry types
+// are general and symmetric. Node keys are read from the comment lines
+// written by WriteMatrixMarket; when missing, the one-based row number
+// is used as the key. Entries equal to zero (or -Inf when isLog is true)
+// are skipped.
+func ReadMatrixMarket(r io.Reader, isLog bool) (*Graph, error) {
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	if !sc.Scan() {
+		if e := sc.Err(); e != nil {
+			return nil, e
+		}
+		return nil, fmt.Errorf("matrix market: empty input")
+	}
+
+	// Parse banner.
+	banner := strings.Fields(strings.ToLower(sc.Text()))
+	if len(banner) != 5 || banner[0] != strings.ToLower(mmBanner) || banner[1] != "matrix" {
+		return nil, fmt.Errorf("matrix market: invalid header [%s]", sc.Text())
+	}
+	if banner[2] != "coordinate" {
+		return nil, fmt.Errorf("matrix market: unsupported format [%s]", banner[2])
+	}
+	field, symmetry := banner[3], banner[4]
+	switch field {
+	case "real", "integer", "pattern":
+	default:
+		return nil, fmt.Errorf("matrix market: unsupported field [%s]", field)
+	}
+	switch symmetry {
+	case "general", "symmetric":
+	default:
+		return nil, fmt.Errorf("matrix market: unsupported symmetry [%s]", symmetry)
+	}
+
+	// Comments with node keys followed by the size line.
+	named := map[int]string{}
+	var rows, cols, nnz int
+	for {
+		if !sc.Scan() {
+			if e := sc.Err(); e != nil {
+				return nil, e
+			}
+			return nil, fmt.Errorf("matrix market: missing size line")
+		}
+		line := strings.TrimSpace(sc.Text())
+		if strings.HasPrefix(line, mmKeyPrefix) {
+			f := strings.SplitN(line[len(mmKeyPrefix):], " ", 2)
+			if len(f) != 2 {
+				return nil, fmt.Errorf("matrix market: invalid key line [%s]", line)
+			}
+			i, e := strconv.Atoi(f[0])
+			if e != nil {
+				return nil, fmt.Errorf("matrix market: invalid key line [%s]: %s", line, e)
+			}
+			key, e := strconv.Unquote(f[1])
+			if e != nil {
+				return nil, fmt.Errorf("matrix market: invalid key line [%s]: %s", line, e)
+			}
+			named[i] = key
+			continue
+		}
+		if line == "" || line[0] == '%' {
+			continue
+		}
+		_, e := fmt.Sscan(line, &rows, &cols, &nnz)
+		if e != nil {
+			return nil, fmt.Errorf("matrix market: invalid size line [%s]: %s", line, e)
+		}
+		break
+	}
+	if rows < 0 || cols < 0 || nnz < 0 {
+		return nil, fmt.Errorf("matrix market: invalid size %dx%d with [%d] entries", rows, cols, nnz)
+	}
+	if rows != cols {
+		return nil, fmt.Errorf("matrix market: matrix must be square, got %dx%d", rows, cols)
+	}
+	// Node indices are stored as uint32 in the binary format.
+	if int64(rows) > math.MaxUint32 {
+		return nil, fmt.Errorf("matrix market: too many rows [%d]", rows)
+	}
+	if rows == 0 && nnz > 0 || rows > 0 && nnz/rows > cols {
+		return nil, fmt.Errorf("matrix market: [%d] entries exceed size %dx%d", nnz, rows, cols)
+	}
+
+	keys := make([]string, rows)
+	for i := range keys {
+		key, ok := named[i+1]
+		if !ok {
+			key = strconv.Itoa(i + 1)
+		}
+		keys[i] = key
+	}
+
+	// Entries are appended as they are read, nnz comes from the input
+	// and is not used to preallocate.
+	coo := &COO{}
+	add := func(i, j int, w float64) {
+		coo.Rows = append(coo.Rows, i)
+		coo.Cols = append(coo.Cols, j)
+		coo.Values = append(coo.Values, w)
+	}
+
+	// Read entries.
+	for k := 0; k < nnz; {
+		if !sc.Scan() {
+			if e := sc.Err(); e != nil {
+				return nil, e
+			}
+			return nil, fmt.Errorf("matrix market: expected [%d] entries, got [%d]", nnz, k)
+		}
+		f := strings.Fields(sc.Text())
+		if len(f) == 0 || f[0][0] == '%' {
+			continue
+		}
+		if (field == "pattern" && len(f) < 2) || (field != "pattern" && len(f) < 3) {
+			return nil, fmt.Errorf("matrix market: invalid entry [%s]", sc.Text())
+		}
+		i, e1 := strconv.Atoi(f[0])
+		j, e2 := strconv.Atoi(f[1])
+		if e1 != nil || e2 != nil {
+			return nil, fmt.Errorf("matrix market: invalid entry [%s]", sc.Text())
+		}
+		w := 1.0
+		if isLog {
+			w = 0
+		}
+		if field != "pattern" {
+			var e error
+			w, e = strconv.ParseFloat(f[2], 64)
+			if e != nil {
+				return nil, fmt.Errorf("matrix market: invalid entry [%s]: %s", sc.Text(), e)
+			}
+		}
+		add(i-1, j-1, w)
+		if symmetry == "symmetric" && i != j {
+			add(j-1, i-1, w)
+		}
+		k++
+	}
+
+	return FromCOO(keys, coo, isLog)
+}
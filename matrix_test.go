@@ -0,0 +1,275 @@
+// Copyright (c) 2013 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graph
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+// Sets all node values to nil so graphs can be compared with graphs
+// built from matrices.
+func clearValues(g *Graph) *Graph {
+	for _, node := range g.nodes {
+		node.value = nil
+	}
+	return g
+}
+
+func TestFromMatrix(t *testing.T) {
+
+	g0 := clearValues(sampleGraph(t))
+	keys, weights, err := g0.TransitionMatrix(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g1, e := FromMatrix(keys, weights, false)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if k := g1.Meta().Weights; k != LinearWeight {
+		t.Fatalf("expected linear weights, got [%s]", k)
+	}
+	if e := compareGraphs(g0, g1); e != nil {
+		t.Fatal(e)
+	}
+
+	// Log domain.
+	g0.ConvertToLogProbs()
+	keys, weights, err = g0.TransitionMatrix(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g2, e := FromMatrix(keys, weights, true)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if k := g2.Meta().Weights; k != LogWeight {
+		t.Fatalf("expected log weights, got [%s]", k)
+	}
+	if e := compareGraphs(g0, g2); e != nil {
+		t.Fatal(e)
+	}
+
+	// Wrong dimensions.
+	_, e = FromMatrix(keys, weights[1:], true)
+	if e == nil {
+		t.Fatalf("expected error for wrong number of rows")
+	}
+}
+
+func TestFromCOOAndCSR(t *testing.T) {
+
+	keys := []string{"a", "b", "c"}
+	coo := &COO{
+		Rows:   []int{2, 0, 0, 1},
+		Cols:   []int{0, 2, 1, 1},
+		Values: []float64{0.5, 0.25, 0.75, 0},
+	}
+	g, e := FromCOO(keys, coo, false)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if ok, w := g.IsConnected("a", "c"); !ok || w != 0.25 {
+		t.Fatalf("expected arc from [a] to [c] with weight 0.25, got [%t] [%f]", ok, w)
+	}
+	if ok, _ := g.IsConnected("b", "b"); ok {
+		t.Fatalf("zero entry should be skipped")
+	}
+
+	csr, e := coo.ToCSR(len(keys))
+	if e != nil {
+		t.Fatal(e)
+	}
+	expPtr := []int{0, 2, 3, 4}
+	expCol := []int{1, 2, 1, 0}
+	for i, v := range expPtr {
+		if csr.RowPtr[i] != v {
+			t.Fatalf("row pointer mismatch at [%d], expected [%d], got [%d]", i, v, csr.RowPtr[i])
+		}
+	}
+	for i, v := range expCol {
+		if csr.ColIdx[i] != v {
+			t.Fatalf("column index mismatch at [%d], expected [%d], got [%d]", i, v, csr.ColIdx[i])
+		}
+	}
+
+	g1, e := FromCSR(keys, csr, false)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if e := compareGraphs(g, g1); e != nil {
+		t.Fatal(e)
+	}
+
+	// Round trip through COO.
+	g2, e := FromCOO(keys, csr.ToCOO(), false)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if e := compareGraphs(g, g2); e != nil {
+		t.Fatal(e)
+	}
+}
+
+func TestMatrixMarket(t *testing.T) {
+
+	g0 := clearValues(sampleGraph(t))
+	g0.Set("a key", nil)
+	g0.Connect("a key", "1", 0.125)
+
+	buf := new(bytes.Buffer)
+	if e := g0.WriteMatrixMarket(buf); e != nil {
+		t.Fatal(e)
+	}
+	t.Logf("mtx:\n%s", buf.String())
+
+	g1, e := ReadMatrixMarket(buf, false)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if e := compareGraphs(g0, g1); e != nil {
+		t.Fatal(e)
+	}
+}
+
+func TestReadMatrixMarketSymmetric(t *testing.T) {
+
+	const mtx = `%%MatrixMarket matrix coordinate real symmetric
+% a comment
+3 3 2
+2 1 0.5
+3 3 -Inf
+`
+	g, e := ReadMatrixMarket(strings.NewReader(mtx), true)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if k := g.Meta().Weights; k != LogWeight {
+		t.Fatalf("expected log weights, got [%s]", k)
+	}
+	if g.Len() != 3 {
+		t.Fatalf("expected 3 nodes, got [%d]", g.Len())
+	}
+	for _, arc := range [][2]string{{"1", "2"}, {"2", "1"}} {
+		if ok, w := g.IsConnected(arc[0], arc[1]); !ok || w != 0.5 {
+			t.Fatalf("expected arc from [%s] to [%s] with weight 0.5, got [%t] [%f]", arc[0], arc[1], ok, w)
+		}
+	}
+	if ok, _ := g.IsConnected("3", "3"); ok {
+		t.Fatalf("-Inf entry should be skipped in log domain")
+	}
+}
+
+func TestReadMatrixMarketPattern(t *testing.T) {
+
+	const mtx = `%%MatrixMarket matrix coordinate pattern general
+2 2 2
+1 2
+2 2
+`
+	for _, isLog := range []bool{false, true} {
+		g, e := ReadMatrixMarket(strings.NewReader(mtx), isLog)
+		if e != nil {
+			t.Fatal(e)
+		}
+		expected := 1.0
+		if isLog {
+			expected = 0
+		}
+		for _, arc := range [][2]string{{"1", "2"}, {"2", "2"}} {
+			if ok, w := g.IsConnected(arc[0], arc[1]); !ok || w != expected {
+				t.Fatalf("isLog [%t]: expected arc from [%s] to [%s] with weight [%f], got [%t] [%f]", isLog, arc[0], arc[1], expected, ok, w)
+			}
+		}
+	}
+}
+
+func TestMatrixErrors(t *testing.T) {
+
+	for _, size := range []string{"2 2 -1", "-2 -2 0", "2 -2 0", "2 2 5", "0 0 1", "4294967296 4294967296 0"} {
+		mtx := "%%MatrixMarket matrix coordinate real general\n" + size + "\n"
+		if _, e := ReadMatrixMarket(strings.NewReader(mtx), false); e == nil {
+			t.Fatalf("size line [%s]: expected error", size)
+		}
+	}
+
+	keys := []string{"a", "b"}
+	for _, m := range []*CSR{
+		{},
+		{RowPtr: []int{0, 1}, ColIdx: []int{0}, Values: []float64{1}},
+		{RowPtr: []int{0, 2, 1}, ColIdx: []int{0}, Values: []float64{1}},
+		{RowPtr: []int{1, 1, 1}, ColIdx: []int{0}, Values: []float64{1}},
+		{RowPtr: []int{0, 0, 2}, ColIdx: []int{0}, Values: []float64{1}},
+	} {
+		if _, e := FromCSR(keys, m, false); e == nil {
+			t.Fatalf("csr %+v: expected error", m)
+		}
+	}
+	if _, e := FromCSR(nil, &CSR{}, false); e == nil {
+		t.Fatal("expected error for empty row pointer")
+	}
+}
+
+func TestSparseMul(t *testing.T) {
+
+	g := sampleGraph(t)
+	g.Normalize(false)
+	keys, dense, err := g.TransitionMatrix(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, m, err := g.SparseTransitionMatrix(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := len(keys)
+
+	x := []float64{0.1, 0.2, 0.3, 0.4}
+	y := m.VecMul(x)
+	z := m.MulVec(x)
+	for j := 0; j < n; j++ {
+		var sy, sz float64
+		for i := 0; i < n; i++ {
+			if len(dense[i]) > 0 {
+				sy += x[i] * dense[i][j]
+			}
+			if len(dense[j]) > 0 {
+				sz += dense[j][i] * x[i]
+			}
+		}
+		if !Comparef64(sy, y[j], 1e-12) {
+			t.Fatalf("VecMul mismatch at [%d]: expected [%f], got [%f]", j, sy, y[j])
+		}
+		if !Comparef64(sz, z[j], 1e-12) {
+			t.Fatalf("MulVec mismatch at [%d]: expected [%f], got [%f]", j, sz, z[j])
+		}
+	}
+
+	// Same results in the log domain.
+	g.ConvertToLogProbs()
+	_, lm, err := g.SparseTransitionMatrix(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lx := make([]float64, n)
+	for i, v := range x {
+		lx[i] = math.Log(v)
+	}
+	ly := lm.LogVecMul(lx)
+	lz := lm.LogMulVec(lx)
+	for j := 0; j < n; j++ {
+		if !Comparef64(math.Exp(ly[j]), y[j], 1e-12) {
+			t.Fatalf("LogVecMul mismatch at [%d]: expected [%f], got [%f]", j, y[j], math.Exp(ly[j]))
+		}
+		if !Comparef64(math.Exp(lz[j]), z[j], 1e-12) {
+			t.Fatalf("LogMulVec mismatch at [%d]: expected [%f], got [%f]", j, z[j], math.Exp(lz[j]))
+		}
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2013 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graph
+
+import (
+	"fmt"
+	"strings"
+)
+
+// WeightKind describes the domain of the arc weights.
+type WeightKind string
+
+const (
+	// UnknownWeight is used when the domain of the weights is not known.
+	// Operations don't check weights of unknown kind.
+	UnknownWeight WeightKind = ""
+	// LinearWeight weights are probabilities or other linear values.
+	LinearWeight WeightKind = "linear"
+	// LogWeight weights are natural log probabilities.
+	LogWeight WeightKind = "log"
+	// CostWeight weights are negative natural log probabilities.
+	CostWeight WeightKind = "cost"
+	// Log10Weight weights are base 10 log probabilities.
+	Log10Weight WeightKind = "log10"
+)
+
+// String returns the name of the weight kind.
+func (k WeightKind) String() string {
+	if k == UnknownWeight {
+		return "unknown"
+	}
+	return string(k)
+}
+
+// Meta holds graph level metadata.
+type Meta struct {
+	// Graph name.
+	Name string
+	// Domain of the arc weights.
+	Weights WeightKind
+	// Free-form attributes.
+	Attrs map[string]string
+}
+
+// Meta returns the graph metadata. The returned value can be modified
+// to update the metadata.
+func (g *Graph) Meta() *Meta {
+	if g.meta == nil {
+		g.meta = &Meta{Attrs: map[string]string{}}
+	}
+	return g.meta
+}
+
+// WeightKindError is returned when an operation is applied to a graph
+// whose weights are in the wrong domain.
+type WeightKindError struct {
+	Op       string
+	Kind     WeightKind
+	Expected []WeightKind
+}
+
+func (e *WeightKindError) Error() string {
+	exp := make([]string, len(e.Expected))
+	for i, k := range e.Expected {
+		exp[i] = k.String()
+	}
+	return fmt.Sprintf("graph: %s requires %s weights, graph has %s weights",
+		e.Op, strings.Join(exp, " or "), e.Kind)
+}
+
+// Returns a WeightKindError if the kind of the graph weights is known
+// and is not one of the expected kinds.
+func (g *Graph) checkWeights(op string, expected ...WeightKind) error {
+
+	kind := g.Meta().Weights
+	if kind == UnknownWeight {
+		return nil
+	}
+	for _, k := range expected {
+		if k == kind {
+			return nil
+		}
+	}
+	return &WeightKindError{Op: op, Kind: kind, Expected: expected}
+}
+
+// Returns the weight kind of the graph that results from adding graphs
+// to g, or a WeightKindError if two of them have different known kinds.
+func (g *Graph) mergeKind(op string, graphs []*Graph) (WeightKind, error) {
+
+	kind := g.Meta().Weights
+	for _, gg := range graphs {
+		if kind == UnknownWeight {
+			kind = gg.Meta().Weights
+			continue
+		}
+		if e := gg.checkWeights(op, kind); e != nil {
+			return kind, e
+		}
+	}
+	return kind, nil
+}
+
+// Returns the weight kind expected by operations that take an isLog flag.
+func logKind(isLog bool) WeightKind {
+	if isLog {
+		return LogWeight
+	}
+	return LinearWeight
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2013 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graph
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWeightKind(t *testing.T) {
+
+	g := sampleGraph(t)
+	if g.Meta().Weights != UnknownWeight {
+		t.Fatalf("expected unknown weights, got [%s]", g.Meta().Weights)
+	}
+	if e := g.Normalize(false); e != nil {
+		t.Fatal(e)
+	}
+	if g.Meta().Weights != LinearWeight {
+		t.Fatalf("expected linear weights, got [%s]", g.Meta().Weights)
+	}
+	if e := g.Normalize(true); e == nil {
+		t.Fatalf("expected error normalizing linear weights in log domain")
+	}
+	if _, _, e := g.TransitionMatrix(true); e == nil {
+		t.Fatalf("expected error for log transition matrix of linear weights")
+	}
+	if e := g.ConvertToLogProbs(); e != nil {
+		t.Fatal(e)
+	}
+
+	// Converting twice is an error.
+	e := g.ConvertToLogProbs()
+	we, ok := e.(*WeightKindError)
+	if !ok {
+		t.Fatalf("expected WeightKindError, got [%v]", e)
+	}
+	if we.Kind != LogWeight {
+		t.Fatalf("expected log weights in error, got [%s]", we.Kind)
+	}
+	t.Log(e)
+
+	// Decoder requires log weights.
+	vg, _ := simpleGraph()
+	vg.Meta().Weights = CostWeight
+	if _, e := NewDecoder(vg); e == nil {
+		t.Fatalf("expected error creating decoder with cost weights")
+	}
+}
+
+func TestMetaIO(t *testing.T) {
+
+	g0 := sampleGraph(t)
+	g0.Normalize(false)
+	g0.Meta().Name = "sample"
+	g0.Meta().Attrs["source"] = "test"
+
+	b, e := json.Marshal(g0)
+	if e != nil {
+		t.Fatal(e)
+	}
+	g1 := New()
+	if e := json.Unmarshal(b, g1); e != nil {
+		t.Fatal(e)
+	}
+	m := g1.Meta()
+	if m.Name != "sample" || m.Weights != LinearWeight || m.Attrs["source"] != "test" {
+		t.Fatalf("unexpected metadata: %+v", m)
+	}
+
+	g2, e := g0.Clone()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if g2.Meta().Name != "sample" || g2.Meta().Weights != LinearWeight {
+		t.Fatalf("unexpected metadata after clone: %+v", g2.Meta())
+	}
+}
+
+func TestMetaCreated(t *testing.T) {
+
+	// String and Clone use exportGraph.
+	g := sampleGraph(t)
+	if c := g.exportGraph().Meta.Created; c != "" {
+		t.Fatalf("unexpected creation time [%s]", c)
+	}
+	buf := new(bytes.Buffer)
+	if e := g.WriteYAML(buf); e != nil {
+		t.Fatal(e)
+	}
+	if year := time.Now().UTC().Format("2006-"); !strings.Contains(buf.String(), year) {
+		t.Fatalf("missing creation time:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,21 @@
+// Copyright (c) 2013 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package graph
+
+import "io/ioutil"
+
+// Reads the whole file into memory on platforms without mmap support.
+func mmapFile(path string) (data []byte, unmap func() error, e error) {
+
+	data, e = ioutil.ReadFile(path)
+	if e != nil {
+		return nil, nil, e
+	}
+	return data, func() error { return nil }, nil
+}
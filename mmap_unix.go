@@ -0,0 +1,38 @@
+// Copyright (c) 2013 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package graph
+
+import (
+	"os"
+	"syscall"
+)
+
+// Maps a file into memory read-only.
+func mmapFile(path string) (data []byte, unmap func() error, e error) {
+
+	f, e := os.Open(path)
+	if e != nil {
+		return nil, nil, e
+	}
+	defer f.Close()
+
+	fi, e := f.Stat()
+	if e != nil {
+		return nil, nil, e
+	}
+	size := fi.Size()
+	if size == 0 {
+		return nil, nil, ErrBinaryFormat
+	}
+	data, e = syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if e != nil {
+		return nil, nil, e
+	}
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
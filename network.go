@@ -0,0 +1,344 @@
+// Copyright (c) 2013 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graph
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// DecoderStartKey is the key of the node where hypotheses start when
+// the decoder is created with explicit initial nodes. The node doesn't
+// belong to the graph and is null.
+const DecoderStartKey = "<decoder>"
+
+// DecoderOptions are the options for NewDecoderWithOptions and NewNetwork.
+type DecoderOptions struct {
+	// Initial node keys and their initial log probabilities. If empty,
+	// the graph must have exactly one start node. If not empty,
+	// hypotheses start in a null node with key DecoderStartKey connected
+	// to the initial nodes with the initial log probabilities.
+	Initial map[string]float64
+	// Final node keys and their final log weights. If empty, the graph
+	// must have exactly one end node and the final weights are computed
+	// from the arcs into the end node (see RequireFinal). If not empty,
+	// the decoder uses the RequireFinal mode and the hypotheses end in a
+	// final node. Final nodes must be emitting nodes.
+	Final map[string]float64
+	// Pruning configuration.
+	Pruning Pruning
+	// How the final hypothesis is chosen.
+	EndMode EndMode
+}
+
+// Network is the immutable part of a Viterbi decoder: the graph, the
+// start and final nodes and the default options. It is created once and
+// can be shared by decoders running in different goroutines. The graph
+// must not be modified while the network is in use and the Score methods
+// of the node values must be safe for concurrent use.
+type Network struct {
+	graph *Graph
+	start *Node
+	end   *Node
+	// Best weight to the end node through null nodes, and the next node
+	// in the best path.
+	final     map[*Node]float64
+	finalNext map[*Node]*Node
+	opt       DecoderOptions
+
+	// Compiled network. Nodes are indexed in key order followed by the
+	// start node when it doesn't belong to the graph. Arcs are stored in
+	// compressed sparse row format sorted by destination index; arcs into
+	// the end node are left out.
+	nodes    []*Node
+	null     []bool
+	scorer   []Viterbier
+	arcStart []int
+	arcTo    []int
+	arcW     []float64
+	startIdx int
+	// Index of the end node or -1.
+	endIdx int
+	// Position of each null node in topological order. Null nodes are
+	// expanded in this order in every frame.
+	nullRank []int
+}
+
+// NewNetwork checks the graph and compiles it into a decoding network.
+// Null nodes must not form a cycle.
+// Arc weights must be log probabilities.
+func NewNetwork(g *Graph, opt DecoderOptions) (*Network, error) {
+
+	net, e := newNetwork(g, opt)
+	if e != nil {
+		return nil, e
+	}
+	if e := net.compile(); e != nil {
+		return nil, e
+	}
+	return net, nil
+}
+
+// Checks the graph and finds the start and final nodes. The network is
+// not compiled.
+func newNetwork(g *Graph, opt DecoderOptions) (*Network, error) {
+
+	// Check that all values in graph implement the Viterbier interface.
+	e := g.checkViterbier()
+	if e != nil {
+		return nil, e
+	}
+
+	// Arc weights are added to log scores.
+	e = g.checkWeights("Decoder", LogWeight)
+	if e != nil {
+		return nil, e
+	}
+
+	net := &Network{graph: g, opt: opt}
+
+	// Search for start and end nodes.
+	if len(opt.Initial) == 0 {
+		starts := g.StartNodes()
+		if len(starts) != 1 {
+			return nil, fmt.Errorf("graph must have exactly one start node. Found: %d", len(starts))
+		}
+		net.start = starts[0]
+	} else {
+		net.start = &Node{key: DecoderStartKey, successors: map[*Node]float64{}}
+		for key, w := range opt.Initial {
+			node := g.get(key)
+			if node == nil {
+				return nil, fmt.Errorf("graph: invalid initial node key [%s]", key)
+			}
+			net.start.successors[node] = w
+		}
+	}
+
+	if len(opt.Final) == 0 {
+		ends := g.EndNodes()
+		if len(ends) != 1 {
+			return nil, fmt.Errorf("graph must have exactly one end node. Found: %d", len(ends))
+		}
+		net.end = ends[0]
+		net.finalWeights()
+	} else {
+		net.opt.EndMode = RequireFinal
+		net.final = make(map[*Node]float64, len(opt.Final))
+		for key, w := range opt.Final {
+			node := g.get(key)
+			if node == nil {
+				return nil, fmt.Errorf("graph: invalid final node key [%s]", key)
+			}
+			if isNull(node) {
+				return nil, fmt.Errorf("graph: final node [%s] is a null node", key)
+			}
+			net.final[node] = w
+		}
+	}
+	return net, nil
+}
+
+// Creates the integer indexed representation of the network. Returns an
+// error if null nodes form a cycle.
+func (net *Network) compile() error {
+
+	nodes, index := net.graph.sortedNodes()
+	if _, ok := index[net.start]; !ok {
+		index[net.start] = len(nodes)
+		nodes = append(nodes, net.start)
+	}
+	n := len(nodes)
+	net.nodes = nodes
+	net.null = make([]bool, n)
+	net.scorer = make([]Viterbier, n)
+	net.startIdx = index[net.start]
+	net.endIdx = -1
+	if net.end != nil {
+		net.endIdx = index[net.end]
+	}
+	net.arcStart = make([]int, n+1)
+	for i, node := range nodes {
+		net.null[i] = isNull(node)
+		net.scorer[i], _ = node.value.(Viterbier)
+		succ, idx := sortedSuccessors(node, index)
+		for k, s := range succ {
+			// Paths that reach the end node are discarded.
+			if idx[k] == net.endIdx {
+				continue
+			}
+			net.arcTo = append(net.arcTo, idx[k])
+			net.arcW = append(net.arcW, node.successors[s])
+		}
+		net.arcStart[i+1] = len(net.arcTo)
+	}
+	return net.rankNulls()
+}
+
+// Ranks the null nodes in topological order. Returns an error if they
+// form a cycle.
+func (net *Network) rankNulls() error {
+
+	order, e := sortNulls(net.nodes, net.null, func(i int, visit func(int)) {
+		for k := net.arcStart[i]; k < net.arcStart[i+1]; k++ {
+			visit(net.arcTo[k])
+		}
+	})
+	if e != nil {
+		return e
+	}
+	net.nullRank = make([]int, len(net.nodes))
+	for rank, i := range order {
+		net.nullRank[i] = rank
+	}
+	return nil
+}
+
+// Sorts the null nodes in topological order considering only arcs
+// between null nodes. null[i] is true if nodes[i] is a null node and
+// succ calls visit with the index of each successor of node i. Returns
+// an error listing the nodes left if null nodes form a cycle.
+func sortNulls(nodes []*Node, null []bool, succ func(i int, visit func(int))) ([]int, error) {
+
+	n := len(nodes)
+	indegree := make([]int, n)
+	var numNull int
+	for i := 0; i < n; i++ {
+		if !null[i] {
+			continue
+		}
+		numNull++
+		succ(i, func(to int) {
+			if null[to] {
+				indegree[to]++
+			}
+		})
+	}
+	var order []int
+	for i := 0; i < n; i++ {
+		if null[i] && indegree[i] == 0 {
+			order = append(order, i)
+		}
+	}
+	for k := 0; k < len(order); k++ {
+		succ(order[k], func(to int) {
+			if !null[to] {
+				return
+			}
+			indegree[to]--
+			if indegree[to] == 0 {
+				order = append(order, to)
+			}
+		})
+	}
+	if len(order) == numNull {
+		return order, nil
+	}
+
+	// Report the nodes in the cycle.
+	var cycle []string
+	for i := 0; i < n; i++ {
+		if null[i] && indegree[i] > 0 {
+			cycle = append(cycle, nodes[i].key)
+		}
+	}
+	return nil, fmt.Errorf("graph: null nodes form a cycle: %s", strings.Join(cycle, ", "))
+}
+
+// Computes the best log weight of the paths from each node to the end
+// node that only go through null nodes.
+func (net *Network) finalWeights() {
+
+	net.final = map[*Node]float64{net.end: 0}
+	net.finalNext = make(map[*Node]*Node)
+
+	// Bellman-Ford relaxation. Weights are log probabilities so there
+	// are no positive cycles; the number of iterations is bounded anyway.
+	for iter := 0; iter < len(net.graph.nodes); iter++ {
+		changed := false
+		for _, node := range net.graph.nodes {
+			if node == net.end {
+				continue
+			}
+			for n, w := range node.successors {
+				f, ok := net.final[n]
+				if !ok || (n != net.end && !isNull(n)) {
+					continue
+				}
+				if old, ok := net.final[node]; !ok || w+f > old {
+					net.final[node] = w + f
+					net.finalNext[node] = n
+					changed = true
+				}
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+}
+
+// NewDecoder creates a decoder that uses the network. The pruning and
+// end mode of the decoder are initialized from the network options.
+func (net *Network) NewDecoder() *Decoder {
+	n := len(net.nodes)
+	return &Decoder{
+		net:     net,
+		pruning: net.opt.Pruning,
+		endMode: net.opt.EndMode,
+		cand:    make([][]*Token, n),
+		scores:  make([]float64, n),
+		scored:  make([]uint64, n),
+	}
+}
+
+// DecodeBatch decodes the observation sequences using up to workers
+// goroutines and returns the best token for each sequence in input order
+// (see Decoder.Decode). The token is nil if no hypothesis is found for a
+// sequence. If workers is zero or negative, one goroutine is used.
+// Returns the context error if the context is cancelled before all the
+// sequences are decoded.
+func (net *Network) DecodeBatch(ctx context.Context, corpus [][]interface{}, workers int) ([]*Token, error) {
+
+	if workers <= 0 {
+		workers = 1
+	}
+	results := make([]*Token, len(corpus))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d := net.NewDecoder()
+			for k := range jobs {
+				results[k] = d.Decode(corpus[k])
+			}
+		}()
+	}
+
+	var err error
+loop:
+	for k := range corpus {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		select {
+		case jobs <- k:
+		case <-ctx.Done():
+			err = ctx.Err()
+			break loop
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
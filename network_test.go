@@ -0,0 +1,169 @@
+// Copyright (c) 2013 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graph
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestDecodeBatch(t *testing.T) {
+
+	corpus := sampleCorpus(t, 40)
+	g := discreteGraph([]float64{0.7, 0.3}, []float64{0.8, 0.1, 0.1}, []float64{0.1, 0.2, 0.7})
+	g.ConvertToLogProbs()
+
+	net, e := NewNetwork(g, DecoderOptions{})
+	if e != nil {
+		t.Fatal(e)
+	}
+	results, e := net.DecodeBatch(context.Background(), corpus, 4)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(results) != len(corpus) {
+		t.Fatalf("expected [%d] results, got [%d]", len(corpus), len(results))
+	}
+	dec := net.NewDecoder()
+	for k, obs := range corpus {
+		expected := dec.Decode(obs)
+		if results[k].Score != expected.Score || results[k].Index != len(obs)-1 {
+			t.Fatalf("sequence %d: expected [%s], got [%s]", k, expected, results[k])
+		}
+	}
+}
+
+func TestDecodeBatchCancel(t *testing.T) {
+
+	corpus := sampleCorpus(t, 10)
+	g := discreteGraph([]float64{0.7, 0.3}, []float64{0.8, 0.1, 0.1}, []float64{0.1, 0.2, 0.7})
+	g.ConvertToLogProbs()
+	net, e := NewNetwork(g, DecoderOptions{})
+	if e != nil {
+		t.Fatal(e)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, e = net.DecodeBatch(ctx, corpus, 2); e != context.Canceled {
+		t.Fatalf("expected context.Canceled, got [%v]", e)
+	}
+}
+
+func TestNetworkDecoders(t *testing.T) {
+
+	net, e := NewNetwork(chainGraph(), DecoderOptions{Pruning: Pruning{MaxActive: 1}})
+	if e != nil {
+		t.Fatal(e)
+	}
+	d1 := net.NewDecoder()
+	d2 := net.NewDecoder()
+	d2.SetPruning(Pruning{})
+
+	// Decoders keep their own state.
+	obs := []interface{}{1, 2, 3}
+	d1.Reset()
+	d2.Reset()
+	for _, o := range obs {
+		d1.Step(o)
+		d2.Step(o)
+	}
+	for k, c := range d1.ActiveCounts() {
+		if c != 1 {
+			t.Fatalf("frame %d: expected 1 active token, got [%d]", k, c)
+		}
+	}
+	if d2.ActiveCounts()[2] <= 1 {
+		t.Fatalf("expected more active tokens, got %v", d2.ActiveCounts())
+	}
+}
+
+func TestNullCycle(t *testing.T) {
+
+	g := nullGraph()
+	g.Connect("n2", "n1", 0)
+	_, e := NewDecoder(g)
+	if e == nil || !strings.Contains(e.Error(), "cycle") {
+		t.Fatalf("expected null cycle error, got [%v]", e)
+	}
+}
+
+func TestNullClosure(t *testing.T) {
+
+	// Long chain of null nodes and a shorter competing path.
+	g := New()
+	g.Set("start", fvalue{null: true})
+	g.Set("n1", fvalue{null: true})
+	g.Set("n2", fvalue{null: true})
+	g.Set("n3", fvalue{null: true})
+	g.Set("n4", fvalue{null: true})
+	g.Set("a", fvalue{f: peakScore(1)})
+	g.Set("end", fvalue{null: true})
+	g.Connect("start", "n1", math.Log(0.9))
+	g.Connect("start", "n4", math.Log(0.1))
+	g.Connect("n1", "n2", 0)
+	g.Connect("n2", "n3", 0)
+	g.Connect("n3", "a", 0)
+	g.Connect("n4", "a", 0)
+	g.Connect("a", "a", math.Log(0.5))
+	g.Connect("a", "end", math.Log(0.5))
+
+	dec, e := NewDecoder(g)
+	if e != nil {
+		t.Fatal(e)
+	}
+	obs := []interface{}{1, 1}
+	best := dec.Decode(obs)
+	expected := math.Log(0.9) + 2*peakScore(1)(1) + math.Log(0.5)
+	if !Comparef64(best.Score, expected, 1e-9) {
+		t.Fatalf("expected score [%f], got [%f]", expected, best.Score)
+	}
+	labels := strings.Join(best.Best().Labels(false), " ")
+	if labels != "start n1 n2 n3 a a" {
+		t.Fatalf("unexpected labels [%s]", labels)
+	}
+
+	// Null paths are different hypotheses but have the same labels.
+	if hyps := dec.DecodeNBest(obs, 5, false); len(hyps) != 2 {
+		t.Fatalf("expected two hypotheses, got [%d]", len(hyps))
+	}
+	if hyps := dec.DecodeNBest(obs, 5, true); len(hyps) != 1 {
+		t.Fatalf("expected one hypothesis, got [%d]", len(hyps))
+	}
+}
+
+func TestNullHub(t *testing.T) {
+
+	// Word loop with a single null node connecting all the words.
+	const numWords = 2000
+	g := New()
+	g.Set("start", fvalue{null: true})
+	g.Set("hub", fvalue{null: true})
+	g.Set("end", fvalue{null: true})
+	g.Connect("start", "hub", 0)
+	g.Connect("hub", "end", math.Log(0.1))
+	for k := 0; k < numWords; k++ {
+		key, word := fmt.Sprintf("w%d", k), k
+		g.Set(key, fvalue{f: func(o interface{}) float64 {
+			if o.(int) == word {
+				return 0
+			}
+			return -10
+		}})
+		g.Connect("hub", key, math.Log(0.9/numWords))
+		g.Connect(key, "hub", 0)
+	}
+	dec, e := NewDecoder(g)
+	if e != nil {
+		t.Fatal(e)
+	}
+	labels := dec.Decode([]interface{}{5, 7, 5}).Best().Labels(true)
+	if strings.Join(labels, " ") != "w5 w7 w5" {
+		t.Fatalf("unexpected labels %v", labels)
+	}
+}
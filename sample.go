@@ -0,0 +1,213 @@
+// Copyright (c) 2013 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graph
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// The Emitter interface is implemented by node values that generate
+// random observations. It is the generative counterpart of the Score
+// method of the Viterbier interface.
+type Emitter interface {
+	Emit(rng *rand.Rand) interface{}
+}
+
+// RandomWalk returns a random sequence of nodes starting at node "start".
+// The next node is chosen with probability proportional to the arc
+// weight. Weights are probabilities or, if the weight kind of the graph
+// is LogWeight, log probabilities. The walk stops when it reaches an end
+// node or after maxLen nodes. Use a Sampler to draw many walks from the
+// same graph.
+func (g *Graph) RandomWalk(start string, rng *rand.Rand, maxLen int) ([]*Node, error) {
+
+	s, e := NewSampler(g, rng)
+	if e != nil {
+		return nil, e
+	}
+	return s.Walk(start, maxLen)
+}
+
+// Sampler generates random walks and observation sequences from a
+// normalized graph. The graph must not be modified while the sampler
+// is in use.
+type Sampler struct {
+	graph *Graph
+	rng   *rand.Rand
+	// Cached successors and cumulative weights.
+	succ map[*Node][]*Node
+	cum  map[*Node][]float64
+	// Start node used by Sample or the error found looking for it.
+	start    *Node
+	startErr error
+	// Null nodes from which the walk can't leave without going through
+	// null nodes only.
+	trapped map[*Node]bool
+}
+
+// NewSampler creates a sampler. Arc weights must be probabilities or log
+// probabilities; if the weight kind of the graph is unknown, weights are
+// assumed to be probabilities. Weights don't need to be normalized.
+func NewSampler(g *Graph, rng *rand.Rand) (*Sampler, error) {
+
+	if e := g.checkWeights("Sampler", LinearWeight, LogWeight); e != nil {
+		return nil, e
+	}
+	isLog := g.Meta().Weights == LogWeight
+
+	s := &Sampler{
+		graph: g,
+		rng:   rng,
+		succ:  make(map[*Node][]*Node, len(g.nodes)),
+		cum:   make(map[*Node][]float64, len(g.nodes)),
+	}
+	for _, node := range g.nodes {
+		if len(node.successors) == 0 {
+			continue
+		}
+		succ := make(Nodes, 0, len(node.successors))
+		for n := range node.successors {
+			succ = append(succ, n)
+		}
+		sort.Sort(ByName{succ})
+
+		cum := make([]float64, len(succ))
+		var sum float64
+		for k, n := range succ {
+			w := node.successors[n]
+			if isLog {
+				w = math.Exp(w)
+			}
+			if w < 0 || math.IsNaN(w) {
+				return nil, fmt.Errorf("graph: invalid probability [%f] in arc from [%s] to [%s]", w, node.key, n.key)
+			}
+			sum += w
+			cum[k] = sum
+		}
+		if sum <= 0 {
+			return nil, fmt.Errorf("graph: outbound arcs of node [%s] have zero probability", node.key)
+		}
+		s.succ[node] = succ
+		s.cum[node] = cum
+	}
+
+	starts := g.StartNodes()
+	if len(starts) == 1 {
+		s.start = starts[0]
+	} else {
+		s.startErr = fmt.Errorf("graph must have exactly one start node. Found: %d", len(starts))
+	}
+	s.findTraps()
+	return s, nil
+}
+
+func isNullValue(v interface{}) bool {
+	vit, ok := v.(Viterbier)
+	return ok && vit.IsNull()
+}
+
+// Finds null nodes that can't reach an emitting node or an end node
+// through arcs with non-zero probability.
+func (s *Sampler) findTraps() {
+
+	pred := make(map[*Node][]*Node)
+	var queue []*Node
+	exits := make(map[*Node]bool)
+	for _, node := range s.graph.nodes {
+		if !isNullValue(node.value) || len(s.cum[node]) == 0 {
+			exits[node] = true
+			queue = append(queue, node)
+		}
+		prev := 0.0
+		for k, n := range s.succ[node] {
+			if s.cum[node][k] > prev {
+				pred[n] = append(pred[n], node)
+			}
+			prev = s.cum[node][k]
+		}
+	}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for _, p := range pred[node] {
+			if !exits[p] {
+				exits[p] = true
+				queue = append(queue, p)
+			}
+		}
+	}
+	s.trapped = make(map[*Node]bool)
+	for _, node := range s.graph.nodes {
+		if !exits[node] {
+			s.trapped[node] = true
+		}
+	}
+}
+
+// Returns a random successor of node or nil if node is an end node.
+func (s *Sampler) next(node *Node) *Node {
+
+	cum := s.cum[node]
+	if len(cum) == 0 {
+		return nil
+	}
+	u := s.rng.Float64() * cum[len(cum)-1]
+	k := sort.Search(len(cum), func(i int) bool { return cum[i] > u })
+	if k == len(cum) {
+		k--
+	}
+	return s.succ[node][k]
+}
+
+// Walk returns a random sequence of nodes starting at node "start". The
+// walk stops when it reaches an end node or after maxLen nodes.
+func (s *Sampler) Walk(start string, maxLen int) ([]*Node, error) {
+
+	node := s.graph.get(start)
+	if node == nil {
+		return nil, errors.New("graph: invalid key")
+	}
+	var walk []*Node
+	for node != nil && len(walk) < maxLen {
+		walk = append(walk, node)
+		node = s.next(node)
+	}
+	return walk, nil
+}
+
+// Sample generates a random sequence of emitting nodes and observations.
+// The walk starts at the start node of the graph, which must be unique,
+// and stops when it reaches an end node or after maxLen emitting nodes.
+// As in the Decoder, the start and end nodes don't emit observations and
+// null nodes (nodes whose values implement Viterbier and are null) are
+// skipped. Values of emitting nodes must implement the Emitter interface.
+// Returns an error if the walk enters a cycle of null nodes that it can't
+// leave.
+func (s *Sampler) Sample(maxLen int) (nodes []*Node, obs []interface{}, err error) {
+
+	if s.startErr != nil {
+		return nil, nil, s.startErr
+	}
+	for node := s.start; node != nil && len(nodes) < maxLen; node = s.next(node) {
+		if s.trapped[node] {
+			return nil, nil, fmt.Errorf("graph: cycle of null nodes at node [%s]", node.key)
+		}
+		if node == s.start || len(s.cum[node]) == 0 || isNullValue(node.value) {
+			continue
+		}
+		em, ok := node.value.(Emitter)
+		if !ok {
+			return nil, nil, fmt.Errorf("value in node [%s] must implement the Emitter interface", node.key)
+		}
+		nodes = append(nodes, node)
+		obs = append(obs, em.Emit(s.rng))
+	}
+	return nodes, obs, nil
+}
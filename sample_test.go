@@ -0,0 +1,237 @@
+// Copyright (c) 2013 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graph
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// Node value that emits a constant observation.
+type evalue struct {
+	null bool
+	obs  int
+}
+
+func (v evalue) Score(o interface{}) float64 { return 0 }
+func (v evalue) IsNull() bool                { return v.null }
+func (v evalue) Emit(rng *rand.Rand) interface{} {
+	return v.obs
+}
+
+func TestRandomWalk(t *testing.T) {
+
+	g := New()
+	for _, k := range []string{"a", "b", "c"} {
+		g.Set(k, nil)
+	}
+	g.Connect("a", "b", 1)
+	g.Connect("b", "c", 1)
+	rng := rand.New(rand.NewSource(1))
+
+	walk, e := g.RandomWalk("a", rng, 10)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(walk) != 3 || walk[0].Key() != "a" || walk[2].Key() != "c" {
+		t.Fatalf("wrong walk %v", walk)
+	}
+	walk, e = g.RandomWalk("a", rng, 2)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(walk) != 2 {
+		t.Fatalf("expected 2 nodes, got [%d]", len(walk))
+	}
+	if _, e = g.RandomWalk("x", rng, 2); e == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestSamplerFrequencies(t *testing.T) {
+
+	for _, isLog := range []bool{false, true} {
+		g := New()
+		for _, k := range []string{"a", "b", "c"} {
+			g.Set(k, nil)
+		}
+		g.Connect("a", "b", 0.3)
+		g.Connect("a", "c", 0.7)
+		if isLog {
+			if e := g.ConvertToLogProbs(); e != nil {
+				t.Fatal(e)
+			}
+		}
+		s, e := NewSampler(g, rand.New(rand.NewSource(42)))
+		if e != nil {
+			t.Fatal(e)
+		}
+		n := 20000
+		var count int
+		for i := 0; i < n; i++ {
+			walk, e := s.Walk("a", 2)
+			if e != nil {
+				t.Fatal(e)
+			}
+			if walk[1].Key() == "b" {
+				count++
+			}
+		}
+		if f := float64(count) / float64(n); !Comparef64(f, 0.3, 0.02) {
+			t.Fatalf("isLog: %t, expected frequency [0.3], got [%f]", isLog, f)
+		}
+	}
+}
+
+func TestSample(t *testing.T) {
+
+	g := New()
+	g.Set("s0", evalue{null: true})
+	g.Set("s1", evalue{obs: 1})
+	g.Set("s2", evalue{obs: 2})
+	g.Set("s3", evalue{null: true})
+	g.Connect("s0", "s1", 1)
+	g.Connect("s1", "s1", 0.5)
+	g.Connect("s1", "s2", 0.5)
+	g.Connect("s2", "s2", 0.5)
+	g.Connect("s2", "s3", 0.5)
+
+	s, e := NewSampler(g, rand.New(rand.NewSource(7)))
+	if e != nil {
+		t.Fatal(e)
+	}
+	for i := 0; i < 100; i++ {
+		nodes, obs, e := s.Sample(1000)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if len(nodes) != len(obs) || len(nodes) < 2 {
+			t.Fatalf("wrong sample length [%d] [%d]", len(nodes), len(obs))
+		}
+		if nodes[0].Key() != "s1" || nodes[len(nodes)-1].Key() != "s2" {
+			t.Fatalf("wrong sample %v", nodes)
+		}
+		for k, o := range obs {
+			if o.(int) != nodes[k].Value().(evalue).obs {
+				t.Fatalf("wrong observation [%v] for node [%s]", o, nodes[k].Key())
+			}
+		}
+	}
+	nodes, _, e := s.Sample(3)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(nodes) > 3 {
+		t.Fatalf("expected at most 3 nodes, got [%d]", len(nodes))
+	}
+}
+
+func TestSampleNullCycle(t *testing.T) {
+
+	g := New()
+	g.Set("s0", evalue{null: true})
+	g.Set("s1", evalue{null: true})
+	g.Set("s2", evalue{null: true})
+	g.Connect("s0", "s1", 1)
+	g.Connect("s1", "s2", 1)
+	g.Connect("s2", "s1", 1)
+
+	s, e := NewSampler(g, rand.New(rand.NewSource(1)))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if _, _, e = s.Sample(10); e == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestSampleNullLoop(t *testing.T) {
+
+	// The walk can leave the null self-loop.
+	g := New()
+	g.Set("s0", evalue{null: true})
+	g.Set("s1", evalue{null: true})
+	g.Set("s2", evalue{obs: 2})
+	g.Set("s3", evalue{null: true})
+	g.Connect("s0", "s1", 1)
+	g.Connect("s1", "s1", 0.95)
+	g.Connect("s1", "s2", 0.05)
+	g.Connect("s2", "s3", 1)
+
+	s, e := NewSampler(g, rand.New(rand.NewSource(1)))
+	if e != nil {
+		t.Fatal(e)
+	}
+	for i := 0; i < 100; i++ {
+		nodes, _, e := s.Sample(1)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if len(nodes) != 1 || nodes[0].Key() != "s2" {
+			t.Fatalf("wrong sample %v", nodes)
+		}
+	}
+}
+
+func TestSampleDecode(t *testing.T) {
+
+	// The start and end nodes emit an observation that no other node
+	// can emit, the decoder doesn't score them.
+	g := discreteGraph([]float64{0.6, 0.4}, []float64{0.9, 0.1, 0}, []float64{0.2, 0.8, 0})
+	g.Set("start", &dvalue{probs: []float64{0, 0, 1}})
+	g.Set("end", &dvalue{probs: []float64{0, 0, 1}})
+	if e := g.ConvertToLogProbs(); e != nil {
+		t.Fatal(e)
+	}
+	s, e := NewSampler(g, rand.New(rand.NewSource(3)))
+	if e != nil {
+		t.Fatal(e)
+	}
+	dec, e := NewDecoder(g)
+	if e != nil {
+		t.Fatal(e)
+	}
+	dec.SetEndMode(RequireFinal)
+	for i := 0; i < 50; i++ {
+		nodes, obs, e := s.Sample(1000)
+		if e != nil {
+			t.Fatal(e)
+		}
+		for k, node := range nodes {
+			if node.Key() != "a" && node.Key() != "b" {
+				t.Fatalf("unexpected node [%s] in sample", node.Key())
+			}
+			if obs[k].(int) == 2 {
+				t.Fatalf("unexpected observation [2] from node [%s]", node.Key())
+			}
+		}
+		tok := dec.Decode(obs)
+		if tok == nil {
+			_, e := dec.Finalize()
+			t.Fatalf("sample %v: %v", obs, e)
+		}
+		var n int
+		for _, bt := range tok.Best() {
+			if k := bt.Node.Key(); k == "a" || k == "b" {
+				n++
+			}
+		}
+		if n != len(obs) || math.IsInf(tok.Score, 0) {
+			t.Fatalf("sample %v: decoded [%d] observations with score [%f]", obs, n, tok.Score)
+		}
+	}
+}
+
+func TestSamplerWeightKind(t *testing.T) {
+
+	g := New()
+	g.Set("a", nil)
+	g.Meta().Weights = CostWeight
+	if _, e := NewSampler(g, rand.New(rand.NewSource(1))); e == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright (c) 2013 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graph
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+// A Semiring defines how arc weights are combined by path algorithms.
+// Times combines the weights of consecutive arcs along a path and Plus
+// combines the weights of alternative paths. Zero is the identity of
+// Plus and One is the identity of Times.
+// (see http://en.wikipedia.org/wiki/Semiring)
+type Semiring interface {
+	Plus(a, b float64) float64
+	Times(a, b float64) float64
+	Zero() float64
+	One() float64
+}
+
+// Semirings may implement this interface to declare the kind of weights
+// they operate on. Path algorithms return an error if the graph weights
+// are of a different kind.
+type weightKinder interface {
+	WeightKind() WeightKind
+}
+
+var (
+	// TropicalSemiring is the (min, +) semiring over costs. Shortest
+	// distances are minimum path costs.
+	TropicalSemiring Semiring = tropical{}
+	// MaxPlusSemiring is the (max, +) semiring over log probabilities.
+	// Shortest distances are Viterbi scores.
+	MaxPlusSemiring Semiring = maxPlus{}
+	// LogSemiring is the (log-sum-exp, +) semiring over log
+	// probabilities. Shortest distances are forward scores.
+	LogSemiring Semiring = logSemiring{}
+	// ProbSemiring is the (+, *) semiring over probabilities.
+	ProbSemiring Semiring = prob{}
+	// BooleanSemiring is the (or, and) semiring. Weights are one (true)
+	// or zero (false). Shortest distances indicate reachability.
+	BooleanSemiring Semiring = boolean{}
+)
+
+var (
+	// ErrCycle is returned by algorithms that require an acyclic graph.
+	ErrCycle = errors.New("graph: graph has a cycle")
+	// ErrNoConvergence is returned by ShortestDistance when the distances
+	// don't converge.
+	ErrNoConvergence = errors.New("graph: shortest distance did not converge")
+)
+
+type tropical struct{}
+
+func (tropical) Plus(a, b float64) float64  { return math.Min(a, b) }
+func (tropical) Times(a, b float64) float64 { return a + b }
+func (tropical) Zero() float64              { return math.Inf(1) }
+func (tropical) One() float64               { return 0 }
+func (tropical) WeightKind() WeightKind     { return CostWeight }
+
+type maxPlus struct{}
+
+func (maxPlus) Plus(a, b float64) float64  { return math.Max(a, b) }
+func (maxPlus) Times(a, b float64) float64 { return a + b }
+func (maxPlus) Zero() float64              { return math.Inf(-1) }
+func (maxPlus) One() float64               { return 0 }
+func (maxPlus) WeightKind() WeightKind     { return LogWeight }
+
+type logSemiring struct{}
+
+func (logSemiring) Plus(a, b float64) float64  { return logAdd(a, b) }
+func (logSemiring) Times(a, b float64) float64 { return a + b }
+func (logSemiring) Zero() float64              { return math.Inf(-1) }
+func (logSemiring) One() float64               { return 0 }
+func (logSemiring) WeightKind() WeightKind     { return LogWeight }
+
+type prob struct{}
+
+func (prob) Plus(a, b float64) float64  { return a + b }
+func (prob) Times(a, b float64) float64 { return a * b }
+func (prob) Zero() float64              { return 0 }
+func (prob) One() float64               { return 1 }
+func (prob) WeightKind() WeightKind     { return LinearWeight }
+
+type boolean struct{}
+
+func (boolean) Plus(a, b float64) float64 {
+	if a != 0 || b != 0 {
+		return 1
+	}
+	return 0
+}
+func (boolean) Times(a, b float64) float64 {
+	if a != 0 && b != 0 {
+		return 1
+	}
+	return 0
+}
+func (boolean) Zero() float64 { return 0 }
+func (boolean) One() float64  { return 1 }
+
+// Relative tolerance used to detect convergence in ShortestDistance.
+const semiringDelta = 1e-12
+
+// Maximum number of relaxations per arc in ShortestDistance.
+const semiringMaxPasses = 100000
+
+// Returns true if a and b are equal within semiringDelta.
+func approxEqual(a, b float64) bool {
+	if a == b {
+		return true
+	}
+	if math.IsInf(a, 0) || math.IsInf(b, 0) {
+		return false
+	}
+	d := math.Abs(a - b)
+	return d <= semiringDelta*math.Max(1, math.Max(math.Abs(a), math.Abs(b)))
+}
+
+// Checks that the graph weights are compatible with the semiring.
+func (g *Graph) checkSemiring(op string, sr Semiring) error {
+	if k, ok := sr.(weightKinder); ok {
+		return g.checkWeights(op, k.WeightKind())
+	}
+	return nil
+}
+
+// ShortestDistance returns the semiring sum of the weights of all paths
+// from the source node to every node in the graph. Nodes that can't be
+// reached have distance sr.Zero(). It uses the generic single-source
+// shortest-distance algorithm described in M. Mohri, "Semiring Frameworks
+// and Algorithms for Shortest-Distance Problems", 2002. For cyclic graphs
+// the result is exact for idempotent semirings (tropical, max-plus,
+// boolean) and converges for the log and probability semirings when the
+// total weight of the cycles is less than one. Returns ErrNoConvergence
+// after semiringMaxPasses relaxations per arc, for example when a cycle
+// has a probability of one or a negative cost.
+func (g *Graph) ShortestDistance(sr Semiring, source string) (map[string]float64, error) {
+
+	start := g.get(source)
+	if start == nil {
+		return nil, errors.New("graph: invalid key")
+	}
+	if e := g.checkSemiring("ShortestDistance", sr); e != nil {
+		return nil, e
+	}
+
+	d := make(map[*Node]float64, g.Len())
+	r := make(map[*Node]float64, g.Len())
+	for _, node := range g.nodes {
+		d[node] = sr.Zero()
+		r[node] = sr.Zero()
+	}
+	d[start] = sr.One()
+	r[start] = sr.One()
+
+	var numArcs int
+	for _, node := range g.nodes {
+		numArcs += len(node.successors)
+	}
+	relax := semiringMaxPasses * (numArcs + 1)
+	queue := []*Node{start}
+	inQueue := map[*Node]bool{start: true}
+	for len(queue) > 0 {
+		q := queue[0]
+		queue = queue[1:]
+		inQueue[q] = false
+		rq := r[q]
+		r[q] = sr.Zero()
+
+		for n, w := range q.successors {
+			x := sr.Times(rq, w)
+			nd := sr.Plus(d[n], x)
+			if approxEqual(d[n], nd) {
+				continue
+			}
+			if relax--; relax < 0 {
+				return nil, ErrNoConvergence
+			}
+			d[n] = nd
+			r[n] = sr.Plus(r[n], x)
+			if !inQueue[n] {
+				queue = append(queue, n)
+				inQueue[n] = true
+			}
+		}
+	}
+
+	dist := make(map[string]float64, len(d))
+	for node, v := range d {
+		dist[node.key] = v
+	}
+	return dist, nil
+}
+
+// PathSum returns the semiring sum of the weights of all paths from
+// node "from" to node "to". See ShortestDistance.
+func (g *Graph) PathSum(sr Semiring, from, to string) (float64, error) {
+
+	if g.get(to) == nil {
+		return 0, errors.New("graph: invalid key")
+	}
+	d, e := g.ShortestDistance(sr, from)
+	if e != nil {
+		return 0, e
+	}
+	return d[to], nil
+}
+
+// TotalWeight returns the semiring sum of the weights of all paths from
+// a start node to an end node. Returns ErrCycle if the graph is not
+// acyclic.
+func (g *Graph) TotalWeight(sr Semiring) (float64, error) {
+
+	if e := g.checkSemiring("TotalWeight", sr); e != nil {
+		return 0, e
+	}
+	order, e := g.TopologicalSort()
+	if e != nil {
+		return 0, e
+	}
+
+	d := make(map[*Node]float64, len(order))
+	for _, node := range order {
+		d[node] = sr.Zero()
+	}
+	for _, node := range g.StartNodes() {
+		d[node] = sr.One()
+	}
+	total := sr.Zero()
+	for _, node := range order {
+		if len(node.successors) == 0 {
+			total = sr.Plus(total, d[node])
+			continue
+		}
+		for n, w := range node.successors {
+			d[n] = sr.Plus(d[n], sr.Times(d[node], w))
+		}
+	}
+	return total, nil
+}
+
+// TopologicalSort returns the nodes sorted such that every arc goes from
+// a node to a node that appears later in the slice. Nodes that are not
+// ordered by arcs are sorted by key. Returns ErrCycle if the graph is
+// not acyclic.
+func (g *Graph) TopologicalSort() ([]*Node, error) {
+
+	nodes, _ := g.sortedNodes()
+	indegree := make(map[*Node]int, len(nodes))
+	for _, node := range nodes {
+		for n := range node.successors {
+			indegree[n]++
+		}
+	}
+
+	// Kahn's algorithm using a queue sorted by key.
+	var ready Nodes
+	for _, node := range nodes {
+		if indegree[node] == 0 {
+			ready = append(ready, node)
+		}
+	}
+	order := make([]*Node, 0, len(nodes))
+	for len(ready) > 0 {
+		node := ready[0]
+		ready = ready[1:]
+		order = append(order, node)
+
+		var next Nodes
+		for n := range node.successors {
+			indegree[n]--
+			if indegree[n] == 0 {
+				next = append(next, n)
+			}
+		}
+		sort.Sort(ByName{next})
+		ready = append(ready, next...)
+	}
+	if len(order) != len(nodes) {
+		return nil, ErrCycle
+	}
+	return order, nil
+}
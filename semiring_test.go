@@ -0,0 +1,144 @@
+// Copyright (c) 2013 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graph
+
+import (
+	"math"
+	"testing"
+)
+
+// Diamond shaped DAG with linear probabilities.
+func diamondGraph() *Graph {
+
+	g := New()
+	for _, k := range []string{"a", "b", "c", "d"} {
+		g.Set(k, nil)
+	}
+	g.Connect("a", "b", 0.25)
+	g.Connect("a", "c", 0.75)
+	g.Connect("b", "d", 0.4)
+	g.Connect("c", "d", 0.8)
+	return g
+}
+
+func TestTotalWeight(t *testing.T) {
+
+	g := diamondGraph()
+	exp := 0.25*0.4 + 0.75*0.8
+
+	w, e := g.TotalWeight(ProbSemiring)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !Comparef64(w, exp, 1e-12) {
+		t.Fatalf("expected [%f], got [%f]", exp, w)
+	}
+
+	w, e = g.TotalWeight(BooleanSemiring)
+	if e != nil || w != 1 {
+		t.Fatalf("expected [1], got [%f] [%v]", w, e)
+	}
+
+	g.ConvertToLogProbs()
+	w, e = g.TotalWeight(LogSemiring)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !Comparef64(w, math.Log(exp), 1e-12) {
+		t.Fatalf("expected [%f], got [%f]", math.Log(exp), w)
+	}
+	w, e = g.TotalWeight(MaxPlusSemiring)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !Comparef64(w, math.Log(0.75*0.8), 1e-12) {
+		t.Fatalf("expected [%f], got [%f]", math.Log(0.75*0.8), w)
+	}
+
+	// Wrong domain.
+	if _, e := g.TotalWeight(ProbSemiring); e == nil {
+		t.Fatalf("expected error using probability semiring with log weights")
+	}
+
+	// Cycle.
+	g.Connect("d", "a", 0)
+	if _, e := g.TotalWeight(LogSemiring); e != ErrCycle {
+		t.Fatalf("expected ErrCycle, got [%v]", e)
+	}
+}
+
+func TestShortestDistance(t *testing.T) {
+
+	// Costs.
+	g := diamondGraph()
+	g.Meta().Weights = CostWeight
+	d, e := g.ShortestDistance(TropicalSemiring, "a")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if d["d"] != 0.65 || d["a"] != 0 {
+		t.Fatalf("unexpected distances: %v", d)
+	}
+	d, e = g.ShortestDistance(TropicalSemiring, "b")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !math.IsInf(d["a"], 1) {
+		t.Fatalf("expected unreachable node to have distance +Inf, got [%f]", d["a"])
+	}
+
+	// Cycle with probabilities.
+	g = New()
+	g.Set("a", nil)
+	g.Set("b", nil)
+	g.Connect("a", "a", 0.5)
+	g.Connect("a", "b", 0.5)
+	w, e := g.PathSum(ProbSemiring, "a", "b")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !Comparef64(w, 1, 1e-9) {
+		t.Fatalf("expected path sum [1], got [%f]", w)
+	}
+	g.ConvertToLogProbs()
+	w, e = g.PathSum(LogSemiring, "a", "b")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !Comparef64(w, 0, 1e-9) {
+		t.Fatalf("expected log path sum [0], got [%f]", w)
+	}
+
+	// Cycles that don't converge.
+	g = New()
+	g.Set("a", nil)
+	g.Set("b", nil)
+	g.Connect("a", "a", 0)
+	g.Connect("a", "b", 0)
+	if _, e := g.ShortestDistance(LogSemiring, "a"); e != ErrNoConvergence {
+		t.Fatalf("expected ErrNoConvergence, got [%v]", e)
+	}
+	g.Connect("a", "a", 1)
+	g.Connect("a", "b", 1)
+	if _, e := g.ShortestDistance(ProbSemiring, "a"); e != ErrNoConvergence {
+		t.Fatalf("expected ErrNoConvergence, got [%v]", e)
+	}
+}
+
+func TestTopologicalSort(t *testing.T) {
+
+	order, e := diamondGraph().TopologicalSort()
+	if e != nil {
+		t.Fatal(e)
+	}
+	var keys string
+	for _, n := range order {
+		keys += n.Key()
+	}
+	if keys != "abcd" {
+		t.Fatalf("expected order [abcd], got [%s]", keys)
+	}
+}
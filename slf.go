@@ -0,0 +1,385 @@
+// Copyright (c) 2013 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graph
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	gob.Register(SLFNode{})
+}
+
+// SLFLink holds the attributes of a lattice link in HTK Standard
+// Lattice Format. Scores are natural logs.
+type SLFLink struct {
+	Word     string  `json:"word,omitempty"`
+	Var      int     `json:"var,omitempty"`
+	Acoustic float64 `json:"a"`
+	LM       float64 `json:"l"`
+	Pron     float64 `json:"r,omitempty"`
+}
+
+// SLFNode is the node value used to represent a lattice node in HTK
+// Standard Lattice Format. Link attributes are indexed by the key of
+// the destination node.
+type SLFNode struct {
+	Time  float64            `json:"t"`
+	Word  string             `json:"word,omitempty"`
+	Var   int                `json:"var,omitempty"`
+	Links map[string]SLFLink `json:"links,omitempty"`
+}
+
+// SLFHeader holds the lattice header fields.
+type SLFHeader struct {
+	Version   string
+	Utterance string
+	// Scale factors used to compute arc weights:
+	//   w = AcScale*a + LMScale*l + PrScale*r + WdPenalty
+	// As in HTK, scales default to one (see NewSLFHeader).
+	AcScale, LMScale, PrScale, WdPenalty float64
+	// Keys of the start and end nodes. Empty if not specified.
+	Start, End string
+	// Other header fields.
+	Extra map[string]string
+}
+
+// NewSLFHeader returns a header with default scale factors.
+func NewSLFHeader() *SLFHeader {
+	return &SLFHeader{AcScale: 1, LMScale: 1, PrScale: 1, Extra: map[string]string{}}
+}
+
+// Arc weight for link l.
+func (h *SLFHeader) weight(l SLFLink) float64 {
+	return h.AcScale*l.Acoustic + h.LMScale*l.LM + h.PrScale*l.Pron + h.WdPenalty
+}
+
+// Long field names and their short equivalent.
+var slfAlias = map[string]string{
+	"VERSION":   "V",
+	"UTTERANCE": "U",
+	"NODES":     "N",
+	"LINKS":     "L",
+	"time":      "t",
+	"WORD":      "W",
+	"var":       "v",
+	"START":     "S",
+	"END":       "E",
+	"acoustic":  "a",
+	"language":  "l",
+}
+
+// ReadSLF reads a lattice in HTK Standard Lattice Format and returns a
+// graph and the lattice header. Each lattice node becomes a graph node
+// keyed by its index (I=) with a value of type SLFNode. Each link becomes
+// an arc whose weight is the combined score (see SLFHeader); the acoustic
+// and LM scores and the word are kept in the SLFNode value of the source
+// node. Scores are converted to natural logs according to the "base"
+// header field and the weight kind of the graph is set to LogWeight.
+// Scale factors missing from the header default to one.
+// Because a graph has at most one arc between two nodes,
+// parallel links are merged keeping the one with the highest weight.
+func ReadSLF(r io.Reader) (*Graph, *SLFHeader, error) {
+
+	h := NewSLFHeader()
+	base := math.E
+	nodes := map[string]SLFNode{}
+	weights := map[[2]string]float64{}
+	var order []string
+
+	// Scale factor to convert scores to natural log.
+	conv := func(v float64) float64 {
+		switch {
+		case base == 0:
+			return math.Log(v)
+		case base == math.E:
+			return v
+		default:
+			return v * math.Log(base)
+		}
+	}
+	node := func(key string) SLFNode {
+		n, ok := nodes[key]
+		if !ok {
+			n = SLFNode{Links: map[string]SLFLink{}}
+			nodes[key] = n
+			order = append(order, key)
+		}
+		return n
+	}
+
+	sc := bufio.NewScanner(r)
+	var line int
+	for sc.Scan() {
+		line++
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		fields, e := slfFields(text)
+		if e != nil {
+			return nil, nil, fmt.Errorf("slf line %d: %s", line, e)
+		}
+		num := func(k string) (float64, error) {
+			v, ok := fields[k]
+			if !ok {
+				return 0, nil
+			}
+			f, e := strconv.ParseFloat(v, 64)
+			if e != nil {
+				return 0, fmt.Errorf("slf line %d: invalid value for field [%s]: %s", line, k, e)
+			}
+			return f, nil
+		}
+
+		switch {
+		case fields["I"] != "":
+			key := fields["I"]
+			n := node(key)
+			var e error
+			if n.Time, e = num("t"); e != nil {
+				return nil, nil, e
+			}
+			n.Word = fields["W"]
+			v, e := num("v")
+			if e != nil {
+				return nil, nil, e
+			}
+			n.Var = int(v)
+			nodes[key] = n
+
+		case fields["J"] != "":
+			from, to := fields["S"], fields["E"]
+			if from == "" || to == "" {
+				return nil, nil, fmt.Errorf("slf line %d: link must have start and end nodes", line)
+			}
+			node(from)
+			node(to)
+			var l SLFLink
+			var e error
+			if l.Acoustic, e = num("a"); e != nil {
+				return nil, nil, e
+			}
+			if l.LM, e = num("l"); e != nil {
+				return nil, nil, e
+			}
+			if l.Pron, e = num("r"); e != nil {
+				return nil, nil, e
+			}
+			v, e := num("v")
+			if e != nil {
+				return nil, nil, e
+			}
+			l.Acoustic, l.LM, l.Pron = conv(l.Acoustic), conv(l.LM), conv(l.Pron)
+			l.Word, l.Var = fields["W"], int(v)
+			w := h.weight(l)
+			if old, ok := weights[[2]string{from, to}]; ok && old >= w {
+				continue
+			}
+			nodes[from].Links[to] = l
+			weights[[2]string{from, to}] = w
+
+		default:
+			// Header.
+			for k, v := range fields {
+				var e error
+				switch k {
+				case "V":
+					h.Version = v
+				case "U":
+					h.Utterance = v
+				case "N", "L":
+				case "start":
+					h.Start = v
+				case "end":
+					h.End = v
+				case "base":
+					base, e = strconv.ParseFloat(v, 64)
+				case "lmscale":
+					h.LMScale, e = strconv.ParseFloat(v, 64)
+				case "acscale":
+					h.AcScale, e = strconv.ParseFloat(v, 64)
+				case "prscale":
+					h.PrScale, e = strconv.ParseFloat(v, 64)
+				case "wdpenalty":
+					h.WdPenalty, e = strconv.ParseFloat(v, 64)
+				default:
+					h.Extra[k] = v
+				}
+				if e != nil {
+					return nil, nil, fmt.Errorf("slf line %d: invalid value for field [%s]: %s", line, k, e)
+				}
+			}
+		}
+	}
+	if e := sc.Err(); e != nil {
+		return nil, nil, e
+	}
+
+	g := New()
+	g.Meta().Weights = LogWeight
+	for _, key := range order {
+		g.Set(key, nodes[key])
+	}
+	for a, w := range weights {
+		g.Connect(a[0], a[1], w)
+	}
+	return g, h, nil
+}
+
+// Splits an SLF line into fields. Long field names are replaced
+// with short names.
+func slfFields(text string) (map[string]string, error) {
+
+	fields := map[string]string{}
+	for _, tok := range strings.Fields(text) {
+		kv := strings.SplitN(tok, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid field [%s]", tok)
+		}
+		k := kv[0]
+		if short, ok := slfAlias[k]; ok {
+			k = short
+		}
+		fields[k] = kv[1]
+	}
+	return fields, nil
+}
+
+// WriteSLF writes the graph as a lattice in HTK Standard Lattice Format.
+// If the header is nil, NewSLFHeader is used. Scale factors are always
+// written. Nodes are numbered from 0 to N-1 in key order, or in numeric
+// order when all keys are integers; keys are preserved by ReadSLF only
+// when they are the integers 0 to N-1. Node times and words, and link
+// scores, are taken from SLFNode values; for other node values, the node
+// key is used as the word and link scores are zero. When the weight of an
+// arc differs from the combined score of its link (see SLFHeader), the
+// acoustic score is adjusted so that reading the lattice back with the
+// same header restores the arc weight. Scores are written as natural logs.
+func (g *Graph) WriteSLF(w io.Writer, h *SLFHeader) error {
+
+	if h == nil {
+		h = NewSLFHeader()
+	}
+	nodes, _ := g.sortedNodes()
+	numeric := true
+	for _, node := range nodes {
+		if _, e := strconv.Atoi(node.key); e != nil {
+			numeric = false
+			break
+		}
+	}
+	if numeric {
+		sort.SliceStable(nodes, func(a, b int) bool {
+			i, _ := strconv.Atoi(nodes[a].key)
+			j, _ := strconv.Atoi(nodes[b].key)
+			return i < j
+		})
+	}
+	index := make(map[*Node]int, len(nodes))
+	var nl int
+	for k, node := range nodes {
+		index[node] = k
+		nl += len(node.successors)
+	}
+	ff := func(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+
+	// Header.
+	bw := bufio.NewWriter(w)
+	version := h.Version
+	if version == "" {
+		version = "1.0"
+	}
+	fmt.Fprintf(bw, "VERSION=%s\n", version)
+	if h.Utterance != "" {
+		fmt.Fprintf(bw, "UTTERANCE=%s\n", h.Utterance)
+	}
+	extra := make([]string, 0, len(h.Extra))
+	for k := range h.Extra {
+		extra = append(extra, k)
+	}
+	sort.Strings(extra)
+	for _, k := range extra {
+		fmt.Fprintf(bw, "%s=%s\n", k, h.Extra[k])
+	}
+	for _, p := range []struct {
+		name string
+		v    float64
+	}{{"acscale", h.AcScale}, {"lmscale", h.LMScale}, {"prscale", h.PrScale}} {
+		fmt.Fprintf(bw, "%s=%s\n", p.name, ff(p.v))
+	}
+	if h.WdPenalty != 0 {
+		fmt.Fprintf(bw, "wdpenalty=%s\n", ff(h.WdPenalty))
+	}
+	for _, p := range [][2]string{{"start", h.Start}, {"end", h.End}} {
+		if p[1] == "" {
+			continue
+		}
+		n := g.get(p[1])
+		if n == nil {
+			return fmt.Errorf("slf %s node [%s] not found in graph", p[0], p[1])
+		}
+		fmt.Fprintf(bw, "%s=%d\n", p[0], index[n])
+	}
+	fmt.Fprintf(bw, "N=%d L=%d\n", len(nodes), nl)
+
+	// Nodes.
+	for _, node := range nodes {
+		v, ok := node.value.(SLFNode)
+		if !ok {
+			v.Word = node.key
+		}
+		fmt.Fprintf(bw, "I=%d\tt=%s", index[node], ff(v.Time))
+		if v.Word != "" {
+			fmt.Fprintf(bw, "\tW=%s", v.Word)
+		}
+		if v.Var != 0 {
+			fmt.Fprintf(bw, "\tv=%d", v.Var)
+		}
+		fmt.Fprintln(bw)
+	}
+
+	// Links.
+	var j int
+	for _, node := range nodes {
+		v, isSLF := node.value.(SLFNode)
+		succ, _ := sortedSuccessors(node, index)
+		for _, s := range succ {
+			w := node.successors[s]
+			l, ok := v.Links[s.key]
+			if !isSLF || !ok {
+				l = SLFLink{}
+			}
+			if lw := h.weight(l); lw != w {
+				if h.AcScale == 0 {
+					return fmt.Errorf("slf link from [%s] to [%s] does not match arc weight and acscale is zero", node.key, s.key)
+				}
+				l.Acoustic += (w - lw) / h.AcScale
+			}
+			fmt.Fprintf(bw, "J=%d\tS=%d\tE=%d", j, index[node], index[s])
+			if l.Word != "" {
+				fmt.Fprintf(bw, "\tW=%s", l.Word)
+			}
+			if l.Var != 0 {
+				fmt.Fprintf(bw, "\tv=%d", l.Var)
+			}
+			fmt.Fprintf(bw, "\ta=%s\tl=%s", ff(l.Acoustic), ff(l.LM))
+			if l.Pron != 0 {
+				fmt.Fprintf(bw, "\tr=%s", ff(l.Pron))
+			}
+			fmt.Fprintln(bw)
+			j++
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2013 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graph
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const slfData = `# Sample lattice.
+VERSION=1.0
+UTTERANCE=test.wav
+lmscale=10.0 wdpenalty=-1.0
+start=0 end=3
+N=4 L=4
+I=0 t=0.00 W=!NULL
+I=1 t=0.30 W=hello
+I=2 t=0.32 W=hallo v=2
+I=3 t=0.80 W=!NULL
+J=0 S=0 E=1 a=-100.0 l=-2.0
+J=1 S=0 E=2 a=-105.0 l=-3.0
+J=2 S=1 E=3 a=-50.0 l=-1.0
+J=3 S=2 E=3 a=-40.0 l=-1.5
+`
+
+func TestSLF(t *testing.T) {
+
+	g, h, e := ReadSLF(strings.NewReader(slfData))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if g.Len() != 4 {
+		t.Fatalf("expected 4 nodes, got [%d]", g.Len())
+	}
+	if h.Utterance != "test.wav" || h.LMScale != 10 || h.WdPenalty != -1 || h.Start != "0" || h.End != "3" {
+		t.Fatalf("unexpected header: %+v", h)
+	}
+	if ok, w := g.IsConnected("0", "1"); !ok || w != -121 {
+		t.Fatalf("expected arc from [0] to [1] with weight -121, got [%t] [%f]", ok, w)
+	}
+	n2, _ := g.Get("2")
+	v := n2.Value().(SLFNode)
+	if v.Word != "hallo" || v.Var != 2 || v.Time != 0.32 {
+		t.Fatalf("unexpected node value: %+v", v)
+	}
+	if l := v.Links["3"]; l.Acoustic != -40 || l.LM != -1.5 {
+		t.Fatalf("unexpected link: %+v", l)
+	}
+
+	// Write and read back.
+	buf := new(bytes.Buffer)
+	if e := g.WriteSLF(buf, h); e != nil {
+		t.Fatal(e)
+	}
+	t.Logf("slf:\n%s", buf.String())
+	g1, h1, e := ReadSLF(buf)
+	if e != nil {
+		t.Fatal(e)
+	}
+	for _, node := range g.GetAll() {
+		n1, e := g1.Get(node.Key())
+		if e != nil {
+			t.Fatal(e)
+		}
+		if !reflect.DeepEqual(node.Value(), n1.Value()) {
+			t.Fatalf("value mismatch for node [%s]: %+v vs. %+v", node.Key(), node.Value(), n1.Value())
+		}
+	}
+	if e := compareGraphs(clearValues(g), clearValues(g1)); e != nil {
+		t.Fatal(e)
+	}
+	if h1.Utterance != h.Utterance || h1.LMScale != h.LMScale || h1.Start != h.Start {
+		t.Fatalf("header mismatch: %+v vs. %+v", h, h1)
+	}
+}
+
+func TestSLFBase(t *testing.T) {
+
+	const lat = `base=10
+N=2 L=1
+I=0
+I=1 W=a
+J=0 S=0 E=1 a=-2 l=-1
+`
+	g, _, e := ReadSLF(strings.NewReader(lat))
+	if e != nil {
+		t.Fatal(e)
+	}
+	n, _ := g.Get("0")
+	l := n.Value().(SLFNode).Links["1"]
+	if !Comparef64(l.Acoustic, -2*math.Log(10), 1e-9) {
+		t.Fatalf("expected acoustic score in natural log, got [%f]", l.Acoustic)
+	}
+	// Scales default to one.
+	if ok, w := g.IsConnected("0", "1"); !ok || !Comparef64(w, -3*math.Log(10), 1e-9) {
+		t.Fatalf("unexpected weight [%f]", w)
+	}
+}
+
+func TestWriteSLFNumbering(t *testing.T) {
+
+	g := New()
+	g.Set("0", nil)
+	g.Set("5", nil)
+	g.Connect("0", "5", -1)
+	buf := new(bytes.Buffer)
+	if e := g.WriteSLF(buf, nil); e != nil {
+		t.Fatal(e)
+	}
+	t.Logf("slf:\n%s", buf.String())
+	g1, h, e := ReadSLF(buf)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if h.LMScale != 1 || h.PrScale != 1 || h.AcScale != 1 {
+		t.Fatalf("unexpected scales: %+v", h)
+	}
+	n, e := g1.Get("1")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if w := n.Value().(SLFNode).Word; w != "5" {
+		t.Fatalf("expected word [5], got [%s]", w)
+	}
+	if ok, w := g1.IsConnected("0", "1"); !ok || w != -1 {
+		t.Fatalf("expected arc from [0] to [1] with weight -1, got [%t] [%f]", ok, w)
+	}
+}
+
+func TestWriteSLFWeights(t *testing.T) {
+
+	const lat = `lmscale=10
+N=2 L=1
+I=0
+I=1 W=a
+J=0 S=0 E=1 a=-2 l=-1
+`
+	g, h, e := ReadSLF(strings.NewReader(lat))
+	if e != nil {
+		t.Fatal(e)
+	}
+	// Change the arc weight, the stored link is now stale.
+	g.Connect("0", "1", -20)
+	for _, wh := range []*SLFHeader{h, {AcScale: 2, LMScale: 10, PrScale: 1, WdPenalty: -1}} {
+		buf := new(bytes.Buffer)
+		if e := g.WriteSLF(buf, wh); e != nil {
+			t.Fatal(e)
+		}
+		g1, _, e := ReadSLF(buf)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if ok, w := g1.IsConnected("0", "1"); !ok || !Comparef64(w, -20, 1e-9) {
+			t.Fatalf("header %+v: expected arc weight -20, got [%t] [%f]", wh, ok, w)
+		}
+	}
+	if e := g.WriteSLF(new(bytes.Buffer), &SLFHeader{LMScale: 1}); e == nil {
+		t.Fatal("expected error for zero acscale")
+	}
+}
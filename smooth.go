@@ -0,0 +1,221 @@
+// Copyright (c) 2013 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graph
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// A SmoothMethod estimates transition probabilities from arc counts.
+// Use Additive or GoodTuring to create one.
+type SmoothMethod interface {
+	// Returns the smoothed probabilities of the seen arcs of node and the
+	// probability of each unseen successor.
+	smooth(node *Node, numNodes int) (seen map[*Node]float64, unseen float64, err error)
+	// Called once before smoothing with all the arc counts in the graph.
+	init(g *Graph)
+}
+
+// Additive returns a smoothing method that adds alpha to the count of
+// every possible transition (alpha = 1 is Laplace smoothing).
+func Additive(alpha float64) SmoothMethod {
+	return additive{alpha: alpha}
+}
+
+type additive struct {
+	alpha float64
+}
+
+func (a additive) init(g *Graph) {}
+
+func (a additive) smooth(node *Node, numNodes int) (map[*Node]float64, float64, error) {
+
+	total := a.alpha * float64(numNodes)
+	for _, c := range node.successors {
+		total += c
+	}
+	if total == 0 {
+		return nil, 0, zeroCountsError(node)
+	}
+	seen := make(map[*Node]float64, len(node.successors))
+	for n, c := range node.successors {
+		seen[n] = (c + a.alpha) / total
+	}
+	return seen, a.alpha / total, nil
+}
+
+// GoodTuring returns a smoothing method that discounts counts up to
+// maxCount using Good-Turing estimates, r* = (r+1) N(r+1) / N(r), where
+// N(r) is the number of arcs in the graph with count r. Counts are
+// rounded to the nearest integer to compute N(r). The probability mass
+// removed by discounting is distributed uniformly among the unseen
+// successors of each node. If maxCount is zero, 5 is used.
+func GoodTuring(maxCount int) SmoothMethod {
+	if maxCount <= 0 {
+		maxCount = 5
+	}
+	return &goodTuring{maxCount: maxCount}
+}
+
+type goodTuring struct {
+	maxCount int
+	// Discounted count indexed by count.
+	discount map[int]float64
+}
+
+func (gt *goodTuring) init(g *Graph) {
+
+	// Count of counts.
+	nr := make(map[int]int)
+	for _, node := range g.nodes {
+		for _, c := range node.successors {
+			nr[int(math.Floor(c+0.5))]++
+		}
+	}
+	gt.discount = make(map[int]float64)
+	for r := 1; r <= gt.maxCount; r++ {
+		if nr[r] == 0 || nr[r+1] == 0 {
+			continue
+		}
+		d := float64(r+1) * float64(nr[r+1]) / float64(nr[r])
+		if d > 0 && d < float64(r) {
+			gt.discount[r] = d
+		}
+	}
+}
+
+func (gt *goodTuring) smooth(node *Node, numNodes int) (map[*Node]float64, float64, error) {
+
+	var total, mass float64
+	for _, c := range node.successors {
+		total += c
+	}
+	if total == 0 {
+		return nil, 0, zeroCountsError(node)
+	}
+	seen := make(map[*Node]float64, len(node.successors))
+	for n, c := range node.successors {
+		d, ok := gt.discount[int(math.Floor(c+0.5))]
+		if !ok || c != math.Floor(c+0.5) {
+			d = c
+		}
+		seen[n] = d / total
+		mass += seen[n]
+	}
+
+	// Back off to uniform distribution.
+	numUnseen := numNodes - len(node.successors)
+	if numUnseen == 0 || mass >= 1 {
+		for n, p := range seen {
+			seen[n] = p / mass
+		}
+		return seen, 0, nil
+	}
+	return seen, (1 - mass) / float64(numUnseen), nil
+}
+
+func zeroCountsError(node *Node) error {
+	return fmt.Errorf("graph: all counts are zero in arcs from [%s]", node.key)
+}
+
+// Smooth replaces arc counts with smoothed transition probabilities.
+// Arc weights must be non-negative counts. Every node with at least one
+// outbound arc gets an arc to every node in the graph, so the graph
+// becomes dense; nodes without outbound arcs are not modified. Returns an
+// error, and leaves the graph unchanged, if the method can't estimate the
+// probabilities of a node, for example when all its counts are zero. The
+// weight kind is set to LinearWeight.
+func (g *Graph) Smooth(method SmoothMethod) error {
+
+	if e := g.checkWeights("Smooth", LinearWeight); e != nil {
+		return e
+	}
+	for _, node := range g.nodes {
+		for n, c := range node.successors {
+			if c < 0 || math.IsNaN(c) {
+				return fmt.Errorf("graph: invalid count [%f] in arc from [%s] to [%s]", c, node.key, n.key)
+			}
+		}
+	}
+
+	method.init(g)
+	nodes := g.GetAll()
+	type estimate struct {
+		seen   map[*Node]float64
+		unseen float64
+	}
+	est := make(map[*Node]estimate, len(nodes))
+	for _, node := range nodes {
+		if len(node.successors) == 0 {
+			continue
+		}
+		seen, unseen, e := method.smooth(node, len(nodes))
+		if e != nil {
+			return e
+		}
+		est[node] = estimate{seen, unseen}
+	}
+	for node, es := range est {
+		for _, n := range nodes {
+			if p, ok := es.seen[n]; ok {
+				node.successors[n] = p
+				continue
+			}
+			if es.unseen > 0 {
+				node.successors[n] = es.unseen
+			}
+		}
+	}
+	g.Meta().Weights = LinearWeight
+	return nil
+}
+
+// PruneArcs removes arcs with a weight lower than threshold and, if
+// keepTopK is greater than zero, keeps at most keepTopK arcs per node
+// with the highest weights. The best arc of a node is never removed so
+// nodes with outbound arcs don't become end nodes. The remaining arcs
+// are renormalized. Weights must be probabilities or log probabilities
+// (the threshold is in the same domain as the weights); if the weight kind
+// of the graph is unknown, weights are assumed to be probabilities.
+func (g *Graph) PruneArcs(threshold float64, keepTopK int) error {
+
+	if e := g.checkWeights("PruneArcs", LinearWeight, LogWeight); e != nil {
+		return e
+	}
+	isLog := g.Meta().Weights == LogWeight
+
+	for _, node := range g.nodes {
+		if len(node.successors) == 0 {
+			continue
+		}
+
+		// Sort arcs by decreasing weight, ties by key.
+		succ := make([]*Node, 0, len(node.successors))
+		for n := range node.successors {
+			succ = append(succ, n)
+		}
+		sort.Slice(succ, func(i, j int) bool {
+			wi, wj := node.successors[succ[i]], node.successors[succ[j]]
+			if wi != wj {
+				return wi > wj
+			}
+			return succ[i].key < succ[j].key
+		})
+
+		for k, n := range succ {
+			if k == 0 {
+				continue
+			}
+			if node.successors[n] < threshold || (keepTopK > 0 && k >= keepTopK) {
+				delete(node.successors, n)
+			}
+		}
+		node.Normalize(isLog)
+	}
+	return nil
+}
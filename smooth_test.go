@@ -0,0 +1,123 @@
+// Copyright (c) 2013 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graph
+
+import (
+	"math"
+	"testing"
+)
+
+// Graph with transition counts.
+func countGraph() *Graph {
+
+	g := New()
+	for _, k := range []string{"a", "b", "c", "d"} {
+		g.Set(k, nil)
+	}
+	g.Connect("a", "b", 1)
+	g.Connect("a", "c", 2)
+	g.Connect("b", "c", 1)
+	g.Connect("b", "a", 1)
+	g.Connect("c", "d", 3)
+	return g
+}
+
+// Checks that outbound weights sum to one.
+func checkSums(t *testing.T, g *Graph, isLog bool) {
+	for _, node := range g.GetAll() {
+		if len(node.Successors()) == 0 {
+			continue
+		}
+		var sum float64
+		for _, w := range node.Successors() {
+			if isLog {
+				w = math.Exp(w)
+			}
+			sum += w
+		}
+		if !Comparef64(sum, 1, 1e-12) {
+			t.Fatalf("weights of node [%s] sum to [%f]", node.Key(), sum)
+		}
+	}
+}
+
+func TestSmoothAdditive(t *testing.T) {
+
+	g := countGraph()
+	if e := g.Smooth(Additive(1)); e != nil {
+		t.Fatal(e)
+	}
+	checkSums(t, g, false)
+	a, _ := g.Get("a")
+	if len(a.Successors()) != 4 {
+		t.Fatalf("expected 4 successors, got [%d]", len(a.Successors()))
+	}
+	if _, w := g.IsConnected("a", "c"); !Comparef64(w, 3.0/7.0, 1e-12) {
+		t.Fatalf("expected [%f], got [%f]", 3.0/7.0, w)
+	}
+	if _, w := g.IsConnected("a", "a"); !Comparef64(w, 1.0/7.0, 1e-12) {
+		t.Fatalf("expected [%f], got [%f]", 1.0/7.0, w)
+	}
+
+	// End nodes are not modified.
+	d, _ := g.Get("d")
+	if len(d.Successors()) != 0 {
+		t.Fatalf("end node should have no successors")
+	}
+}
+
+func TestSmoothGoodTuring(t *testing.T) {
+
+	// N(1) = 3, N(2) = 1 so count 1 is discounted to 2/3.
+	g := countGraph()
+	if e := g.Smooth(GoodTuring(0)); e != nil {
+		t.Fatal(e)
+	}
+	checkSums(t, g, false)
+
+	_, wb := g.IsConnected("a", "b")
+	if !Comparef64(wb, (2.0/3.0)/3.0, 1e-12) {
+		t.Fatalf("expected [%f], got [%f]", (2.0/3.0)/3.0, wb)
+	}
+	_, wa := g.IsConnected("a", "a")
+	_, wd := g.IsConnected("a", "d")
+	if wa <= 0 || wa != wd {
+		t.Fatalf("expected equal non-zero probabilities for unseen arcs, got [%f] and [%f]", wa, wd)
+	}
+
+	// Counts must be linear.
+	g.Meta().Weights = LogWeight
+	if e := g.Smooth(Additive(1)); e == nil {
+		t.Fatalf("expected error smoothing log weights")
+	}
+}
+
+func TestSmoothZeroCounts(t *testing.T) {
+
+	// Node d has an arc with a zero count.
+	zeroGraph := func() *Graph {
+		g := countGraph()
+		g.Connect("d", "a", 0)
+		return g
+	}
+	for _, method := range []SmoothMethod{Additive(0), GoodTuring(0)} {
+		g := zeroGraph()
+		if e := g.Smooth(method); e == nil {
+			t.Fatalf("%T: expected error for node with zero counts", method)
+		}
+		// The graph is not modified.
+		if e := compareGraphs(g, zeroGraph()); e != nil {
+			t.Fatal(e)
+		}
+	}
+
+	// Additive smoothing with alpha > 0 gives a uniform distribution.
+	g := zeroGraph()
+	if e := g.Smooth(Additive(1)); e != nil {
+		t.Fatal(e)
+	}
+	checkSums(t, g, false)
+}
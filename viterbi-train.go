@@ -0,0 +1,90 @@
+// Copyright (c) 2013 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graph
+
+import (
+	"math"
+
+	"github.com/golang/glog"
+)
+
+// ViterbiTrain re-estimates the arc weights of the graph in place using
+// Viterbi training (segmental k-means). In each iteration, every sequence
+// in the corpus is decoded and the arcs along the best path are counted.
+// The new weight of an arc is the log of its relative count; arcs that
+// are not used get a log weight of -Inf. Nodes that are not visited keep
+// their weights. As in BaumWelch, arcs that go into the end node keep
+// their weights. The graph must satisfy the requirements of NewDecoder.
+// Returns the total Viterbi score of the corpus before each iteration.
+// Sequences that can't be decoded are skipped.
+func ViterbiTrain(g *Graph, corpus [][]interface{}, iters int) ([]float64, error) {
+
+	var scores []float64
+	for iter := 0; iter < iters; iter++ {
+
+		// The decoder compiles the arc weights so it is created again
+		// after every update.
+		dec, e := NewDecoder(g)
+		if e != nil {
+			return scores, e
+		}
+		counts := make(map[*Node]map[*Node]float64)
+		var total float64
+		var numDecoded int
+		for k, obs := range corpus {
+			best := dec.Decode(obs)
+			if best == nil || math.IsInf(best.Score, -1) {
+				glog.Warningf("skipping sequence %d: no path found", k)
+				continue
+			}
+			total += best.Score
+			numDecoded++
+			hyp := best.Best()
+			for i := 1; i < len(hyp); i++ {
+				from, to := hyp[i-1].Node, hyp[i].Node
+				if counts[from] == nil {
+					counts[from] = make(map[*Node]float64)
+				}
+				counts[from][to]++
+			}
+		}
+		if numDecoded == 0 {
+			return scores, ErrZeroLikelihood
+		}
+		scores = append(scores, total)
+		glog.V(2).Infof("viterbi training iteration: %d, score: %f", iter, total)
+
+		for from, m := range counts {
+			from.reestimate(m, dec.net.end)
+		}
+	}
+	return scores, nil
+}
+
+// Replaces the outbound arc weights with the log of the normalized
+// counts. The probability of the arc into the end node is preserved.
+func (node *Node) reestimate(counts map[*Node]float64, end *Node) {
+
+	var total float64
+	for _, c := range counts {
+		total += c
+	}
+	var pEnd float64
+	if w, ok := node.successors[end]; ok {
+		pEnd = math.Exp(w)
+	}
+	if total == 0 || pEnd >= 1 {
+		return
+	}
+	for n := range node.successors {
+		node.successors[n] = counts[n]
+	}
+	if pEnd > 0 {
+		node.successors[end] = pEnd / (1 - pEnd) * total
+	}
+	node.Normalize(false)
+	node.ConvertToLogProbs()
+}
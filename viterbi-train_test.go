@@ -0,0 +1,51 @@
+// Copyright (c) 2013 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graph
+
+import (
+	"math"
+	"testing"
+)
+
+func TestViterbiTrain(t *testing.T) {
+
+	corpus := sampleCorpus(t, 300)
+	g := discreteGraph([]float64{0.5, 0.5}, []float64{0.8, 0.1, 0.1}, []float64{0.1, 0.2, 0.7})
+	g.ConvertToLogProbs()
+
+	scores, e := ViterbiTrain(g, corpus, 5)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(scores) != 5 {
+		t.Fatalf("expected 5 scores, got [%d]", len(scores))
+	}
+	for k := 1; k < len(scores); k++ {
+		if scores[k] < scores[k-1]-1e-6 {
+			t.Fatalf("score decreased: %v", scores)
+		}
+	}
+	if scores[len(scores)-1] <= scores[0] {
+		t.Fatalf("score didn't improve: %v", scores)
+	}
+	checkSums(t, g, true)
+	_, w := g.IsConnected("a", "a")
+	if !Comparef64(math.Exp(w), 0.7, 0.1) {
+		t.Fatalf("expected weight close to [0.7], got [%f]", math.Exp(w))
+	}
+	if _, w = g.IsConnected("b", "end"); !Comparef64(w, math.Log(0.2), 1e-12) {
+		t.Fatalf("end arc modified: [%f]", math.Exp(w))
+	}
+}
+
+func TestViterbiTrainErrors(t *testing.T) {
+
+	g := discreteGraph([]float64{0.5, 0.5}, []float64{0.8, 0.1, 0.1}, []float64{0.1, 0.2, 0.7})
+	g.Meta().Weights = LinearWeight
+	if _, e := ViterbiTrain(g, [][]interface{}{{0}}, 1); e == nil {
+		t.Fatal("expected weight kind error")
+	}
+}
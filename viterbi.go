@@ -7,8 +7,10 @@ package graph
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"math"
+	"sort"
 
 	"github.com/golang/glog"
 )
@@ -53,154 +55,665 @@ type Token struct {
 	BT *Token
 	// Sequence index.
 	Index int
+	// Hash of the sequence of emitting node keys, used to find
+	// duplicate hypotheses in N-best decoding.
+	labels uint64
+	// Index of the node in the compiled network.
+	node int
 }
 
 // Decoder finds the sequence of nodes in the graph that maximizes
 // the score of a sequence of N observations using the Viterbi algorithm.
 // (see http://en.wikipedia.org/wiki/Viterbi_algorithm)
 // The node values must implement the Viterbier interface.
+// A Decoder holds the state of the sequence being decoded and must not be
+// used by more than one goroutine at a time; use Network.NewDecoder to
+// create decoders that share the same network.
+//
+// Tokens that survive a frame are kept until the end of the sequence so
+// that backtraces remain valid. They are allocated in chunks of 1024 and
+// released to the garbage collector on Reset, so decoding a sequence
+// allocates about one Token per surviving hypothesis per frame, plus the
+// frame count returned by ActiveCounts. The rest of the frame loop
+// doesn't allocate once the decoder has warmed up.
 type Decoder struct {
-	graph  *Graph
-	start  *Node
-	end    *Node
-	active []*Token
-	hyps   map[*Node][]*Token
+	// Decoders created from the same network can be used concurrently.
+	net     *Network
+	active  []*Token
+	pruning Pruning
+	// Candidate tokens for each node in the current frame, the emitting
+	// nodes that have candidates and the null nodes that have candidates
+	// and haven't been expanded, a heap ordered by rank.
+	cand    [][]*Token
+	touched []int
+	nulls   []int
+	// Buffer for the next list of active tokens.
+	next []*Token
+	// Tokens created in the current frame. Reused in every frame.
+	scratch tokenPool
+	// Tokens that survive a frame. Released on Reset.
+	tokens tokenPool
+	// Emission scores cached for the current frame.
+	scores []float64
+	scored []uint64
+	stamp  uint64
+	sorter tokenSorter
+	// Number of tokens kept per node and whether tokens with identical
+	// label sequences are merged.
+	nbest  int
+	unique bool
+	// Records token transitions when generating a lattice.
+	lattice *latticeRec
+	// Index of the next observation.
+	frame int
+	// Best score in the previous frame.
+	prevBest float64
+	// Number of active tokens after pruning in each frame.
+	activeCounts []int
+	endMode      EndMode
+}
+
+// EndMode determines how the decoder chooses the final hypothesis.
+type EndMode int
+
+const (
+	// BestPartial chooses the best hypothesis after the last observation
+	// whether or not it can reach the end node.
+	BestPartial EndMode = iota
+	// RequireFinal only accepts hypotheses that can reach the end node
+	// after the last observation going through null nodes only. The
+	// weights of the arcs in the path to the end node (final weights)
+	// are added to the score.
+	RequireFinal
+)
+
+var (
+	// ErrNoPath is returned when the decoder can't find a hypothesis.
+	ErrNoPath = errors.New("graph: no path found")
+	// ErrNoCompletePath is returned in RequireFinal mode when no
+	// hypothesis reaches the end node.
+	ErrNoCompletePath = errors.New("graph: no complete path found")
+)
+
+// Pruning configures the pruning of hypotheses in the Decoder. Beams
+// are differences of log scores relative to the best score in the
+// frame. A zero value disables the corresponding pruning.
+type Pruning struct {
+	// Tokens whose score is lower than the frame best minus Beam are
+	// discarded.
+	Beam float64
+	// Maximum number of active tokens per frame (histogram pruning).
+	MaxActive int
+	// Tokens are not passed through null nodes if their score is lower
+	// than the best score of the previous frame minus NullBeam.
+	NullBeam float64
+	// Beam applied to tokens in word-end nodes. It is usually tighter
+	// than Beam.
+	WordEndBeam float64
+	// Returns true if the node is a word end. Required to use WordEndBeam.
+	IsWordEnd func(node *Node) bool
 }
 
 // NewDecoder creates a new Viterbi decoder.
 // Graph must have exactly one start and one end node. Will return error otherwise.
+// Null nodes must not form a cycle.
+// Arc weights must be log probabilities.
 func NewDecoder(g *Graph) (*Decoder, error) {
+	return NewDecoderWithOptions(g, DecoderOptions{})
+}
 
-	// Search for start and end nodes.
-	starts := g.StartNodes()
-	if len(starts) != 1 {
-		return nil, fmt.Errorf("graph must have exactly one start node. Found: %d", len(starts))
-	}
-	ends := g.EndNodes()
-	if len(ends) != 1 {
-		return nil, fmt.Errorf("graph must have exactly one end node. Found: %d", len(ends))
-	}
+// NewDecoderWithOptions creates a new Viterbi decoder with explicit
+// initial and final nodes (see DecoderOptions).
+// Arc weights must be log probabilities.
+func NewDecoderWithOptions(g *Graph, opt DecoderOptions) (*Decoder, error) {
 
-	// Check that all values in graph implement the Viterbier interface.
-	e := g.checkViterbier()
+	net, e := NewNetwork(g, opt)
 	if e != nil {
 		return nil, e
 	}
-
-	d := &Decoder{graph: g, start: starts[0], end: ends[0]}
-	// d := &Decoder{graph: g, start: starts[0], end: ends[0], active: []*Token{}}
-
-	// // Initialization. First active hypothesis for start node.
-	// t := &Token{
-	// 	Score: 0,
-	// 	Node:  starts[0],
-	// 	BT:    nil,
-	// 	Index: -1,
-	// }
-	// d.active = append(d.active, t)
-
-	return d, nil
+	return net.NewDecoder(), nil
 }
 
 // Decode returns the Viterbi path and total score.
 // The argument is a slice of observations.
+// Returns nil if no hypothesis is found; use Finalize to get the error.
 func (d *Decoder) Decode(obs []interface{}) *Token {
 	glog.V(3).Infof("start decoding sequence with %d observations", len(obs))
 
+	d.Reset()
+	for _, o := range obs {
+		d.Step(o)
+	}
+	t, _ := d.Finalize()
+	return t
+}
+
+// Reset prepares the decoder to decode a new sequence one observation
+// at a time using Step. Tokens returned for the previous sequence remain
+// valid; the decoder allocates new ones for the next sequence.
+func (d *Decoder) Reset() {
+
 	// Initialization. First active hypothesis for start node.
-	t := &Token{
+	// Tokens of the previous sequence remain valid.
+	d.tokens.release()
+	t := d.tokens.get()
+	*t = Token{
 		Score: 0,
-		Node:  d.start,
+		Node:  d.net.start,
 		BT:    nil,
 		Index: -1,
+		node:  d.net.startIdx,
+	}
+	d.active = append(d.active[:0], t)
+	d.frame = 0
+	d.prevBest = 0
+	d.activeCounts = nil
+}
+
+// Step decodes the next observation. Calls Reset if the decoder has not
+// been initialized.
+func (d *Decoder) Step(o interface{}) {
+
+	if d.active == nil {
+		d.Reset()
+	}
+	if glog.V(5) {
+		glog.Infof("propagate obs with index: %4d, value: %+v", d.frame, o)
+	}
+	d.propagate(d.frame, o)
+	d.frame++
+}
+
+// PartialBest returns the best hypothesis for the observations decoded
+// so far. Returns nil if there are no active hypotheses.
+func (d *Decoder) PartialBest() Hyp {
+
+	best := maxScore(d.active)
+	if best == nil {
+		return nil
+	}
+	return best.Best()
+}
+
+// Stable returns the part of the hypothesis shared by all the active
+// tokens. It won't change as more observations are decoded, so it can be
+// output before the end of the sequence. The returned hypothesis starts
+// at the start node and grows over time; callers keep track of the
+// tokens that were already output. Returns nil if there are no active
+// hypotheses.
+func (d *Decoder) Stable() Hyp {
+
+	if len(d.active) == 0 {
+		return nil
+	}
+	cur := append([]*Token(nil), d.active...)
+	for {
+		same := true
+		max := cur[0].Index
+		for _, t := range cur[1:] {
+			if t != cur[0] {
+				same = false
+			}
+			if t.Index > max {
+				max = t.Index
+			}
+		}
+		if same {
+			return cur[0].Best()
+		}
+		// Move the most recent tokens back.
+		for k, t := range cur {
+			if t.Index == max {
+				if t.BT == nil {
+					return nil
+				}
+				cur[k] = t.BT
+			}
+		}
 	}
-	d.active = []*Token{t}
-	for k, o := range obs {
-		glog.V(5).Infof("propagate obs with index: %4d, value: %+v", k, o)
-		d.propagate(k, o)
+}
+
+// Finalize returns the token with the best score after the last
+// observation. The backtrace of the token is the Viterbi path. In
+// RequireFinal mode, the token is on the end node and the score includes
+// the final weight; returns ErrNoCompletePath if no hypothesis can reach
+// the end node. Otherwise, returns ErrNoPath if there are no active
+// hypotheses.
+func (d *Decoder) Finalize() (*Token, error) {
+
+	best := maxScore(d.finalTokens())
+	switch {
+	case best != nil:
+		return best, nil
+	case d.endMode == RequireFinal:
+		return nil, ErrNoCompletePath
 	}
-	return maxScore(d.active)
+	return nil, ErrNoPath
 }
 
-func (d *Decoder) createToken(prev *Token, node *Node, idx int, score float64) *Token {
+// SetEndMode sets how the final hypothesis is chosen.
+func (d *Decoder) SetEndMode(m EndMode) {
+	d.endMode = m
+}
 
-	nt := &Token{
-		Score: score,
-		Node:  node,
-		BT:    prev,
-		Index: idx,
+// Returns the weight added to hypotheses that end in node and whether
+// they are complete.
+func (d *Decoder) finalWeight(node *Node) (float64, bool) {
+	if d.endMode != RequireFinal {
+		return 0, true
 	}
+	f, ok := d.net.final[node]
+	return f, ok && node != d.net.end
+}
+
+// Returns the tokens that are candidates for the final hypothesis. In
+// RequireFinal mode, active tokens are extended to the end node, or get
+// the final weight of their node when final nodes are explicit, and
+// tokens that can't complete a path are discarded.
+func (d *Decoder) finalTokens() []*Token {
 
-	// No null nodes.
-	if !node.Value().(Viterbier).IsNull() {
-		d.hyps[node] = append(d.hyps[node], nt)
+	if d.endMode != RequireFinal {
+		return d.active
+	}
+	var tokens []*Token
+	for _, t := range d.active {
+		if _, ok := d.finalWeight(t.Node); !ok {
+			continue
+		}
+		// Create tokens for the null nodes in the path to the end node.
+		nt := t
+		for n := d.net.finalNext[t.Node]; n != nil; n = d.net.finalNext[n] {
+			nt = &Token{Score: nt.Score + nt.Node.successors[n], Node: n, BT: nt, Index: t.Index, labels: nt.labels}
+		}
+		if nt == t {
+			// Final node, add the final weight.
+			nt = &Token{Score: t.Score + d.net.final[t.Node], Node: t.Node, BT: t.BT, Index: t.Index, labels: t.labels}
+		}
+		tokens = append(tokens, nt)
+	}
+	return tokens
+}
+
+func (d *Decoder) createToken(prev *Token, node int, idx int, score float64) *Token {
+
+	nt := d.scratch.get()
+	*nt = Token{
+		Score:  score,
+		Node:   d.net.nodes[node],
+		BT:     prev,
+		Index:  idx,
+		labels: prev.labels,
+		node:   node,
+	}
+
+	switch {
+	case d.net.null[node]:
+		if len(d.cand[node]) == 0 {
+			d.pushNull(node)
+		}
+	case len(d.cand[node]) == 0:
+		d.touched = append(d.touched, node)
 	}
+	if d.unique && !d.net.null[node] {
+		nt.labels = hashLabel(prev.labels, nt.Node.key)
+	}
+	d.cand[node] = append(d.cand[node], nt)
 	return nt
 }
 
+// Adds a null node to the heap of null nodes waiting to be expanded.
+func (d *Decoder) pushNull(node int) {
+	rank := d.net.nullRank
+	h := append(d.nulls, node)
+	for i := len(h) - 1; i > 0; {
+		p := (i - 1) / 2
+		if rank[h[p]] <= rank[h[i]] {
+			break
+		}
+		h[p], h[i] = h[i], h[p]
+		i = p
+	}
+	d.nulls = h
+}
+
+// Removes and returns the null node with the lowest rank.
+func (d *Decoder) popNull() int {
+	rank := d.net.nullRank
+	h := d.nulls
+	node := h[0]
+	n := len(h) - 1
+	h[0] = h[n]
+	h = h[:n]
+	for i := 0; ; {
+		c := 2*i + 1
+		if c >= n {
+			break
+		}
+		if c+1 < n && rank[h[c+1]] < rank[h[c]] {
+			c++
+		}
+		if rank[h[i]] <= rank[h[c]] {
+			break
+		}
+		h[i], h[c] = h[c], h[i]
+		i = c
+	}
+	d.nulls = h
+	return node
+}
+
+// Combines the hash of a label sequence with the next label using the
+// FNV-1a hash function.
+func hashLabel(h uint64, label string) uint64 {
+	const (
+		offset = 14695981039346656037
+		prime  = 1099511628211
+	)
+	x := uint64(offset)
+	for i := 0; i < 8; i++ {
+		x ^= uint64(byte(h >> (8 * uint(i))))
+		x *= prime
+	}
+	for i := 0; i < len(label); i++ {
+		x ^= uint64(label[i])
+		x *= prime
+	}
+	return x
+}
+
+// Returns the emission score of a node. Scores are computed once per frame.
+func (d *Decoder) score(node int, o interface{}) float64 {
+	if d.scored[node] != d.stamp {
+		d.scores[node] = d.net.scorer[node].Score(o)
+		d.scored[node] = d.stamp
+	}
+	return d.scores[node]
+}
+
+// Passes a token to the successors of its node. Tokens that go into null
+// nodes are expanded later by expandNulls.
 func (d *Decoder) pass(t *Token, idx int, o interface{}) {
 
-	for node, w := range t.Node.successors {
-		val := node.Value().(Viterbier)
-		glog.V(6).Infof("pass from [%s] to [%s] null:%t, token: [%+v]", t.Node.key, node.key, val.IsNull(), t)
+	for k := d.net.arcStart[t.node]; k < d.net.arcStart[t.node+1]; k++ {
+		node, w := d.net.arcTo[k], d.net.arcW[k]
+		if glog.V(6) {
+			glog.Infof("pass from [%s] to [%s] null:%t, token: [%+v]", t.Node.key, d.net.nodes[node].key, d.net.null[node], t)
+		}
+
+		if d.net.null[node] {
+			if d.pruning.NullBeam > 0 && t.Score+w < d.prevBest-d.pruning.NullBeam {
+				continue
+			}
+			d.createToken(t, node, idx, t.Score+w)
+			continue
+		}
+
+		// Emitting node.
+		ac := d.score(node, o)
+		nt := d.createToken(t, node, idx, t.Score+w+ac)
+		if d.lattice != nil {
+			d.lattice.addArc(nt, ac)
+		}
+	}
+}
 
-		// Reached end node.
+// Expands the null nodes that received tokens in topological order so
+// that each null node is expanded once per frame with the best tokens
+// that reach it. When generating a lattice, the best token from each
+// source token is kept so that no transition is lost.
+func (d *Decoder) expandNulls(idx int, o interface{}) {
+
+	for len(d.nulls) > 0 {
+		node := d.popNull()
+		cand := d.cand[node]
 		switch {
-		case node == d.end:
-			// Discard this hyp. We need the last node to be an emitting node.
-			// TODO: for now we are ignoring the end node. Do we need an end node?
-			nt := d.createToken(t, node, idx, math.Inf(-1))
-			glog.V(6).Info("end node reached")
-			d.pass(nt, idx, o)
-		case val.IsNull():
-			// Keep passing recursively until finding an emitting node.
-			nt := d.createToken(t, node, idx, t.Score+w)
-			glog.V(6).Infof("null node: %s, token: [%+v]", node.key, nt)
-			d.pass(nt, idx, o)
+		case d.lattice != nil:
+			for _, t := range d.bestPerSource(cand, idx) {
+				d.pass(t, idx, o)
+			}
+		case d.nbest > 1:
+			for _, t := range d.topTokens(cand, d.nbest) {
+				d.pass(t, idx, o)
+			}
 		default:
-			// Emitting node.
-			f := node.value.(Viterbier).Score // scoring function for this node.
-			nt := d.createToken(t, node, idx, t.Score+w+f(o))
-			glog.V(6).Infof("emit node: %s, token: [%+v]", node.key, nt)
+			if best := maxScore(cand); best != nil {
+				d.pass(best, idx, o)
+			}
+		}
+		for k := range cand {
+			cand[k] = nil
 		}
+		d.cand[node] = cand[:0]
 	}
 }
 
+// Returns the best token for each token of the previous frame that
+// tokens created in frame idx come from.
+func (d *Decoder) bestPerSource(tokens []*Token, idx int) []*Token {
+
+	best := make(map[*Token]*Token, len(tokens))
+	var srcs []*Token
+	for _, t := range tokens {
+		src := t.BT
+		for src.BT != nil && src.Index == idx {
+			src = src.BT
+		}
+		if b, ok := best[src]; !ok || t.Score > b.Score {
+			if !ok {
+				srcs = append(srcs, src)
+			}
+			best[src] = t
+		}
+	}
+	res := make([]*Token, len(srcs))
+	for k, src := range srcs {
+		res[k] = best[src]
+	}
+	return res
+}
+
+// Copies a token created in frame idx, and the null tokens created in
+// the same frame in its backtrace, to the pool of surviving tokens.
+func (d *Decoder) persist(t *Token, idx int) *Token {
+
+	nt := d.tokens.get()
+	*nt = *t
+	for p := nt; p.BT != nil && p.BT.Index == idx; p = p.BT {
+		c := d.tokens.get()
+		*c = *p.BT
+		p.BT = c
+	}
+	return nt
+}
+
 // Propagate tokens from nodes to successors.
 // Keeps the tokens that maximizes the score.
 func (d *Decoder) propagate(idx int, o interface{}) {
 
-	// Init data structure to hold candidate hypothesis before choosing the most likely.
-	// TODO consider avoid realloc memory
-	d.hyps = make(map[*Node][]*Token)
-	for _, node := range d.graph.GetAll() {
-		d.hyps[node] = []*Token{}
-	}
+	// Candidate tokens are discarded after each frame.
+	d.scratch.reset()
+	d.stamp++
 
 	// Iterate.
 	for _, t := range d.active {
 		d.pass(t, idx, o)
 	}
+	d.expandNulls(idx, o)
 
 	// We have all the candidates for all nodes. Keep the most likely.
 	// Remove others.
-	var active []*Token
-	for _, node := range d.graph.nodes {
-		best := maxScore(d.hyps[node])
-		if best != nil {
-			active = append(active, best)
+	active := d.next[:0]
+	for _, node := range d.touched {
+		cand := d.cand[node]
+		if d.nbest > 1 {
+			for _, t := range d.topTokens(cand, d.nbest) {
+				active = append(active, d.persist(t, idx))
+			}
+		} else if best := maxScore(cand); best != nil {
+			active = append(active, d.persist(best, idx))
 		}
+		for k := range cand {
+			cand[k] = nil
+		}
+		d.cand[node] = cand[:0]
 	}
+	d.touched = d.touched[:0]
 
 	// Replace list of active hypotheses.
-	d.active = active
+	d.next = d.active[:0]
+	d.active = d.prune(active)
+	d.activeCounts = append(d.activeCounts, len(d.active))
+	if best := maxScore(d.active); best != nil {
+		d.prevBest = best.Score
+	}
+	if d.lattice != nil {
+		d.lattice.addFrame(d.active)
+	}
 
 	if glog.V(6) {
-		printActive(active)
+		printActive(d.active)
 	}
 	return
 }
 
+// SetPruning sets the pruning configuration.
+func (d *Decoder) SetPruning(p Pruning) {
+	d.pruning = p
+}
+
+// ActiveCounts returns the number of active tokens after pruning for
+// each frame of the last decoded sequence.
+func (d *Decoder) ActiveCounts() []int {
+	return d.activeCounts
+}
+
+// Applies beam and histogram pruning to the active tokens.
+func (d *Decoder) prune(active []*Token) []*Token {
+
+	p := d.pruning
+	best := maxScore(active)
+	if best == nil {
+		return active
+	}
+	kept := active[:0]
+	for _, t := range active {
+		if p.Beam > 0 && t.Score < best.Score-p.Beam {
+			continue
+		}
+		if p.WordEndBeam > 0 && p.IsWordEnd != nil && p.IsWordEnd(t.Node) &&
+			t.Score < best.Score-p.WordEndBeam {
+			continue
+		}
+		kept = append(kept, t)
+	}
+	if p.MaxActive > 0 && len(kept) > p.MaxActive {
+		d.sorter = kept
+		sort.Sort(&d.sorter)
+		d.sorter = nil
+		kept = kept[:p.MaxActive]
+	}
+	return kept
+}
+
+// Sorts tokens by decreasing score, ties by node key.
+type tokenSorter []*Token
+
+func (s *tokenSorter) Len() int      { return len(*s) }
+func (s *tokenSorter) Swap(i, j int) { (*s)[i], (*s)[j] = (*s)[j], (*s)[i] }
+func (s *tokenSorter) Less(i, j int) bool {
+	a, b := (*s)[i], (*s)[j]
+	if a.Score != b.Score {
+		return a.Score > b.Score
+	}
+	return a.Node.key < b.Node.key
+}
+
+// Number of tokens allocated at once by a tokenPool.
+const tokenChunkSize = 1024
+
+// A tokenPool allocates tokens in chunks to reduce the number of memory
+// allocations.
+type tokenPool struct {
+	chunks [][]Token
+	chunk  int
+	pos    int
+}
+
+// Returns a token from the pool. The caller must initialize all fields.
+func (p *tokenPool) get() *Token {
+	if p.chunk == len(p.chunks) {
+		p.chunks = append(p.chunks, make([]Token, tokenChunkSize))
+	}
+	t := &p.chunks[p.chunk][p.pos]
+	p.pos++
+	if p.pos == tokenChunkSize {
+		p.chunk++
+		p.pos = 0
+	}
+	return t
+}
+
+// Makes all the tokens available again. Previously returned tokens
+// must not be used.
+func (p *tokenPool) reset() {
+	p.chunk, p.pos = 0, 0
+}
+
+// Drops the chunks. Previously returned tokens remain valid.
+func (p *tokenPool) release() {
+	p.chunks = nil
+	p.chunk, p.pos = 0, 0
+}
+
+// DecodeNBest returns up to n hypotheses with the highest scores, sorted
+// by decreasing score. It keeps the n best tokens in every node instead
+// of only the best one. If unique is true, hypotheses with identical
+// label sequences (see Hyp.Labels) are merged and only the best one is
+// kept. Pruning options also apply; MaxActive counts all the tokens.
+func (d *Decoder) DecodeNBest(obs []interface{}, n int, unique bool) []Hyp {
+
+	d.nbest, d.unique = n, unique
+	defer func() { d.nbest, d.unique = 0, false }()
+
+	d.Decode(obs)
+	tokens := d.topTokens(d.finalTokens(), n)
+	hyps := make([]Hyp, len(tokens))
+	for i, t := range tokens {
+		hyps[i] = t.Best()
+	}
+	return hyps
+}
+
+// Returns up to n tokens sorted by decreasing score. Tokens with a score
+// of -Inf are discarded. If d.unique is true, tokens with the same label
+// sequence as a better token are discarded.
+func (d *Decoder) topTokens(tokens []*Token, n int) []*Token {
+
+	sorted := make([]*Token, 0, len(tokens))
+	for _, t := range tokens {
+		if !math.IsInf(t.Score, -1) {
+			sorted = append(sorted, t)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Score > sorted[j].Score })
+
+	seen := make(map[uint64]bool)
+	top := sorted[:0]
+	for _, t := range sorted {
+		if len(top) == n {
+			break
+		}
+		if d.unique {
+			if seen[t.labels] {
+				continue
+			}
+			seen[t.labels] = true
+		}
+		top = append(top, t)
+	}
+	return top
+}
+
 // Returns token with max score.
 func maxScore(tokens []*Token) *Token {
 	var best *Token
@@ -260,6 +773,13 @@ func (t *Token) IsNull() bool {
 	return t.Node.Value().(Viterbier).IsNull()
 }
 
+// Returns true if the node value is null or doesn't implement the
+// Viterbier interface, as the start node created for initial nodes.
+func isNull(node *Node) bool {
+	v, ok := node.value.(Viterbier)
+	return !ok || v.IsNull()
+}
+
 // A Hyp is a type to represent a hypothesis returned by the decoder.
 // The underlying type is slice of tokens.
 // Hyp methods are used to extract information.
@@ -277,6 +797,14 @@ func (t *Token) Best() Hyp {
 	return bt
 }
 
+// Score returns the total score of the hypothesis.
+func (h Hyp) Score() float64 {
+	if len(h) == 0 {
+		return math.Inf(-1)
+	}
+	return h[len(h)-1].Score
+}
+
 // Labels returns the sequence of labels in a hypothesis.
 // If noNull is true, null nodes are not included in the
 // returned value.
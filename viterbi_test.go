@@ -0,0 +1,408 @@
+// Copyright (c) 2013 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graph
+
+import (
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var pruneObs = []interface{}{1, 1, 2, 2, 3, 3, 2, 3}
+
+func TestPruningBeam(t *testing.T) {
+
+	g := chainGraph()
+	dec, e := NewDecoder(g)
+	if e != nil {
+		t.Fatal(e)
+	}
+	ref := dec.Decode(pruneObs)
+	refCounts := append([]int(nil), dec.ActiveCounts()...)
+	if len(refCounts) != len(pruneObs) {
+		t.Fatalf("expected [%d] counts, got [%d]", len(pruneObs), len(refCounts))
+	}
+
+	// A wide beam doesn't change the result.
+	dec.SetPruning(Pruning{Beam: 1000})
+	tok := dec.Decode(pruneObs)
+	if tok.Score != ref.Score || !reflect.DeepEqual(tok.Best().Labels(true), ref.Best().Labels(true)) {
+		t.Fatalf("expected [%s], got [%s]", ref.BacktraceString(), tok.BacktraceString())
+	}
+	if !reflect.DeepEqual(dec.ActiveCounts(), refCounts) {
+		t.Fatalf("expected counts %v, got %v", refCounts, dec.ActiveCounts())
+	}
+
+	// A narrow beam reduces the number of active tokens.
+	dec.SetPruning(Pruning{Beam: 0.5})
+	tok = dec.Decode(pruneObs)
+	if tok.Score > ref.Score {
+		t.Fatalf("pruned score [%f] greater than [%f]", tok.Score, ref.Score)
+	}
+	var total, refTotal int
+	for k, c := range dec.ActiveCounts() {
+		total += c
+		refTotal += refCounts[k]
+	}
+	if total >= refTotal {
+		t.Fatalf("expected fewer active tokens, got %v, ref: %v", dec.ActiveCounts(), refCounts)
+	}
+}
+
+func TestPruningMaxActive(t *testing.T) {
+
+	dec, e := NewDecoder(chainGraph())
+	if e != nil {
+		t.Fatal(e)
+	}
+	dec.SetPruning(Pruning{MaxActive: 1})
+	tok := dec.Decode(pruneObs)
+	if tok == nil {
+		t.Fatal("expected a hypothesis")
+	}
+	for k, c := range dec.ActiveCounts() {
+		if c != 1 {
+			t.Fatalf("frame %d: expected 1 active token, got [%d]", k, c)
+		}
+	}
+}
+
+func TestPruningWordEnd(t *testing.T) {
+
+	dec, e := NewDecoder(nullGraph())
+	if e != nil {
+		t.Fatal(e)
+	}
+	obs := []interface{}{1, 1, 2, 1}
+	dec.Decode(obs)
+	refCounts := append([]int(nil), dec.ActiveCounts()...)
+
+	dec.SetPruning(Pruning{
+		WordEndBeam: 1e-6,
+		IsWordEnd:   func(node *Node) bool { return node.Key() == "b" },
+	})
+	if tok := dec.Decode(obs); tok == nil {
+		t.Fatal("expected a hypothesis")
+	}
+	for k, c := range dec.ActiveCounts() {
+		if c > refCounts[k] {
+			t.Fatalf("frame %d: expected at most [%d] tokens, got [%d]", k, refCounts[k], c)
+		}
+	}
+	// In frame 1, "b" is worse than "a" and is pruned.
+	if dec.ActiveCounts()[1] != 1 {
+		t.Fatalf("expected 1 active token, got %v", dec.ActiveCounts())
+	}
+}
+
+func TestPruningNullBeam(t *testing.T) {
+
+	dec, e := NewDecoder(nullGraph())
+	if e != nil {
+		t.Fatal(e)
+	}
+	obs := []interface{}{1, 1, 2, 1}
+	ref := dec.Decode(obs)
+	dec.SetPruning(Pruning{NullBeam: 1000})
+	if tok := dec.Decode(obs); tok.Score != ref.Score {
+		t.Fatalf("expected score [%f], got [%f]", ref.Score, tok.Score)
+	}
+
+	// Null nodes are entered at a cost of at least log(0.5); a tiny beam
+	// blocks all paths through null nodes after the first frame.
+	dec.SetPruning(Pruning{NullBeam: 1e-6})
+	tok := dec.Decode(obs)
+	for _, label := range tok.Best().Labels(true) {
+		if label != "a" {
+			t.Fatalf("expected only [a] in path, got %v", tok.Best().Labels(true))
+		}
+	}
+}
+
+// Returns the scores of all paths, or of the best path for each label
+// sequence if unique is true, sorted by decreasing score.
+func bruteForceNBest(g *Graph, obs []interface{}, unique bool) []float64 {
+
+	end := g.EndNodes()[0]
+	best := make(map[string]float64)
+	var all []float64
+	var rec func(node *Node, t int, acc float64, labels string)
+	rec = func(node *Node, t int, acc float64, labels string) {
+		if t == len(obs) && !node.value.(Viterbier).IsNull() {
+			if s, ok := best[labels]; !ok || acc > s {
+				best[labels] = acc
+			}
+			all = append(all, acc)
+		}
+		for n, w := range node.successors {
+			v := n.value.(Viterbier)
+			switch {
+			case n == end:
+			case v.IsNull():
+				rec(n, t, acc+w, labels)
+			case t < len(obs):
+				rec(n, t+1, acc+w+v.Score(obs[t]), labels+" "+n.key)
+			}
+		}
+	}
+	rec(g.StartNodes()[0], 0, 0, "")
+	if unique {
+		all = all[:0]
+		for _, s := range best {
+			all = append(all, s)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.Float64Slice(all)))
+	return all
+}
+
+func TestDecodeNBest(t *testing.T) {
+
+	obs := []interface{}{1, 2, 2, 1, 2}
+	for _, unique := range []bool{false, true} {
+		for _, g := range []*Graph{chainGraph(), nullGraph()} {
+			dec, e := NewDecoder(g)
+			if e != nil {
+				t.Fatal(e)
+			}
+			expected := bruteForceNBest(g, obs, unique)
+			hyps := dec.DecodeNBest(obs, 5, unique)
+			if len(hyps) != 5 {
+				t.Fatalf("expected 5 hypotheses, got [%d]", len(hyps))
+			}
+			seen := make(map[string]bool)
+			for k, h := range hyps {
+				if !Comparef64(h.Score(), expected[k], 1e-9) {
+					t.Fatalf("unique: %t, hyp %d: expected score [%f], got [%f]", unique, k, expected[k], h.Score())
+				}
+				labels := strings.Join(h.Labels(true), " ")
+				if unique && seen[labels] {
+					t.Fatalf("duplicate hypothesis [%s]", labels)
+				}
+				seen[labels] = true
+			}
+
+			// Best hypothesis matches Decode.
+			if best := dec.Decode(obs); best.Score != hyps[0].Score() {
+				t.Fatalf("expected score [%f], got [%f]", best.Score, hyps[0].Score())
+			}
+		}
+	}
+}
+
+func TestStreaming(t *testing.T) {
+
+	obs := []interface{}{1, 1, 2, 2, 3, 3, 2, 3}
+	dec, e := NewDecoder(chainGraph())
+	if e != nil {
+		t.Fatal(e)
+	}
+	ref, e := NewDecoder(chainGraph())
+	if e != nil {
+		t.Fatal(e)
+	}
+	final := ref.Decode(obs).Best().Labels(true)
+
+	dec.Reset()
+	var stable []string
+	for k, o := range obs {
+		dec.Step(o)
+
+		// Partial result is the best path for the prefix.
+		expected := ref.Decode(obs[:k+1])
+		partial := dec.PartialBest()
+		if partial.Score() != expected.Score {
+			t.Fatalf("frame %d: expected score [%f], got [%f]", k, expected.Score, partial.Score())
+		}
+
+		// The stable prefix only grows and agrees with the final result.
+		s := dec.Stable().Labels(true)
+		if len(s) < len(stable) || strings.Join(s[:len(stable)], " ") != strings.Join(stable, " ") {
+			t.Fatalf("frame %d: stable prefix changed from %v to %v", k, stable, s)
+		}
+		if len(s) > len(final) || strings.Join(s, " ") != strings.Join(final[:len(s)], " ") {
+			t.Fatalf("frame %d: stable prefix %v doesn't match %v", k, s, final)
+		}
+		stable = s
+	}
+	if len(stable) == 0 {
+		t.Fatal("expected a stable prefix")
+	}
+	tok, e := dec.Finalize()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !reflect.DeepEqual(tok.Best().Labels(true), final) {
+		t.Fatalf("expected %v, got %v", final, tok.Best().Labels(true))
+	}
+}
+
+func TestRequireFinal(t *testing.T) {
+
+	obs := []interface{}{1, 1, 1}
+	dec, e := NewDecoder(chainGraph())
+	if e != nil {
+		t.Fatal(e)
+	}
+	if labels := dec.Decode(obs).Best().Labels(true); labels[len(labels)-1] != "s1" {
+		t.Fatalf("expected partial path ending in [s1], got %v", labels)
+	}
+
+	// Best complete path is s1 s1 s3.
+	dec.SetEndMode(RequireFinal)
+	expected := math.Log(0.4) + math.Log(0.1) + peakScore(3)(1) + math.Log(0.4)
+	dec.Reset()
+	for _, o := range obs {
+		dec.Step(o)
+	}
+	tok, e := dec.Finalize()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if tok.Node.Key() != "s4" || !Comparef64(tok.Score, expected, 1e-9) {
+		t.Fatalf("expected score [%f] at [s4], got [%f] at [%s]", expected, tok.Score, tok.Node.Key())
+	}
+	if labels := strings.Join(tok.Best().Labels(true), " "); labels != "s1 s1 s3" {
+		t.Fatalf("expected [s1 s1 s3], got [%s]", labels)
+	}
+	if labels := strings.Join(tok.Best().Labels(false), " "); labels != "s0 s1 s1 s3 s4" {
+		t.Fatalf("expected [s0 s1 s1 s3 s4], got [%s]", labels)
+	}
+
+	for _, h := range dec.DecodeNBest(obs, 3, false) {
+		if h[len(h)-1].Node.Key() != "s4" {
+			t.Fatalf("incomplete hypothesis %v", h.Labels(false))
+		}
+	}
+
+	lat, e := dec.DecodeLattice(obs, 0)
+	if e != nil {
+		t.Fatal(e)
+	}
+	v, e := lat.TotalWeight(MaxPlusSemiring)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !Comparef64(v, expected, 1e-9) {
+		t.Fatalf("expected lattice score [%f], got [%f]", expected, v)
+	}
+
+	// One observation can't reach the end node.
+	dec.Reset()
+	dec.Step(1)
+	if _, e = dec.Finalize(); e != ErrNoCompletePath {
+		t.Fatalf("expected ErrNoCompletePath, got [%v]", e)
+	}
+	if tok = dec.Decode(obs[:1]); tok != nil {
+		t.Fatalf("expected nil token, got [%s]", tok)
+	}
+	if _, e = dec.DecodeLattice(obs[:1], 0); e != ErrNoCompletePath {
+		t.Fatalf("expected ErrNoCompletePath, got [%v]", e)
+	}
+}
+
+func TestDecoderOptions(t *testing.T) {
+
+	// Same model with and without start and end nodes.
+	withNull := chainGraph()
+	withNull.Connect("s0", "s1", math.Log(0.5))
+	withNull.Connect("s0", "s2", math.Log(0.5))
+
+	g := chainGraph()
+	g.Delete("s0")
+	g.Delete("s4")
+	if len(g.StartNodes()) != 0 {
+		t.Fatalf("expected no start nodes")
+	}
+
+	ref, e := NewDecoder(withNull)
+	if e != nil {
+		t.Fatal(e)
+	}
+	ref.SetEndMode(RequireFinal)
+	dec, e := NewDecoderWithOptions(g, DecoderOptions{
+		Initial: map[string]float64{"s1": math.Log(0.5), "s2": math.Log(0.5)},
+		Final:   map[string]float64{"s3": math.Log(0.4)},
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	for _, obs := range [][]interface{}{{1, 1, 1}, {2, 2, 3}, {3, 1, 2, 3}} {
+		expected := ref.Decode(obs)
+		tok := dec.Decode(obs)
+		if !Comparef64(tok.Score, expected.Score, 1e-9) {
+			t.Fatalf("obs %v: expected score [%f], got [%f]", obs, expected.Score, tok.Score)
+		}
+		if a, b := strings.Join(tok.Best().Labels(true), " "), strings.Join(expected.Best().Labels(true), " "); a != b {
+			t.Fatalf("obs %v: expected [%s], got [%s]", obs, b, a)
+		}
+		if tok.Node.Key() != "s3" {
+			t.Fatalf("expected final node [s3], got [%s]", tok.Node.Key())
+		}
+	}
+	if bt := dec.Decode([]interface{}{1, 3}).Best(); bt[0].Node.Key() != DecoderStartKey {
+		t.Fatalf("expected start key [%s], got [%s]", DecoderStartKey, bt[0].Node.Key())
+	}
+
+	// Errors.
+	if _, e = NewDecoderWithOptions(g, DecoderOptions{Initial: map[string]float64{"x": 0}, Final: map[string]float64{"s3": 0}}); e == nil {
+		t.Fatal("expected error")
+	}
+	if _, e = NewDecoderWithOptions(g, DecoderOptions{Initial: map[string]float64{"s1": 0}}); e == nil {
+		t.Fatal("expected error")
+	}
+	if _, e = NewDecoderWithOptions(withNull, DecoderOptions{Initial: map[string]float64{"s1": 0}, Final: map[string]float64{"s4": 0}}); e == nil {
+		t.Fatal("expected error for null final node")
+	}
+}
+
+// Warms up the decoder and returns a function that decodes n frames.
+func stepper(dec *Decoder) func(n int) {
+
+	obs := []interface{}{1, 2}
+	dec.Reset()
+	for k := 0; k < 20; k++ {
+		dec.Step(obs[k%2])
+	}
+	return func(n int) {
+		for k := 0; k < n; k++ {
+			dec.Step(obs[k%2])
+		}
+	}
+}
+
+func BenchmarkDecoderStep(b *testing.B) {
+
+	dec, e := NewDecoder(nullGraph())
+	if e != nil {
+		b.Fatal(e)
+	}
+	step := stepper(dec)
+	b.ReportAllocs()
+	b.ResetTimer()
+	step(b.N)
+}
+
+func TestDecoderAllocs(t *testing.T) {
+
+	dec, e := NewDecoder(nullGraph())
+	if e != nil {
+		t.Fatal(e)
+	}
+	step := stepper(dec)
+
+	// Only surviving tokens are allocated, in chunks, so decoding
+	// tokenChunkSize frames takes one allocation per surviving token per
+	// frame, plus the occasional growth of the active counts.
+	allocs := testing.AllocsPerRun(20, func() { step(tokenChunkSize) })
+	tokens := float64(dec.tokens.chunk*tokenChunkSize+dec.tokens.pos) / float64(dec.frame)
+	if allocs > tokens+2 {
+		t.Fatalf("expected about [%.2f] allocations per [%d] frames, got [%.0f]", tokens, tokenChunkSize, allocs)
+	}
+}